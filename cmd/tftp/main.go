@@ -1,10 +1,21 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"tftp/internal/client"
+	protocol "tftp/internal/protocol/parse"
+	"tftp/internal/utils"
+	"time"
+
+	"github.com/dustin/go-humanize"
 )
 
 func Transfer(hostPath string, remotePath string, hostToRemote bool) {
@@ -13,35 +24,150 @@ func Transfer(hostPath string, remotePath string, hostToRemote bool) {
 
 var validModes = map[string]struct{}{"get": {}, "put": {}}
 
+// Exit codes, so scripts can tell why a transfer failed.
+const (
+	EXIT_SUCCESS        = 0
+	EXIT_FAILURE        = 1  // Any failure not listed below.
+	EXIT_USAGE          = 2  // Invalid flags.
+	EXIT_TIMEOUT        = 3  // The server stopped answering, or -timeout passed.
+	EXIT_CANCELLED      = 4  // Interrupted.
+	EXIT_FILE_NOT_FOUND = 5  // The server sent ERROR 1.
+	EXIT_ACCESS_DENIED  = 6  // The server sent ERROR 2.
+	EXIT_DISK_FULL      = 7  // The server sent ERROR 3.
+	EXIT_SERVER_ERROR   = 8  // The server sent any other ERROR.
+	EXIT_PROTOCOL       = 9  // Either side broke the protocol.
+	EXIT_UNKNOWN_TID    = 10 // The server did not recognize the transfer.
+	EXIT_LOCAL_IO       = 11 // Reading or writing the local file failed.
+)
+
 func main() {
 
 	mode := flag.String("mode", "put", "To write (put) to or read (get) a file from remote.")
 	remoteAddress := flag.String("remote-address", "", "Remote server address")
 	local := flag.String("host-path", "", "The path on the host to read from or write to.")
 	remote := flag.String("remote-path", "", "The path on remote to read from or write to.")
+	blockSize := flag.Int("blksize", 0, "Block size to negotiate (8-65464), 0 for the default of 512.")
+	transferMode := flag.String("transfer-mode", "octet", "Transfer mode: octet or netascii (translates line endings).")
+	windowSize := flag.Int("windowsize", 0, "Window size to negotiate (1-64, each window is buffered in memory), 0 for lock-step transfers.")
+	var portRange utils.PortRange
+	flag.Func("port-range", "Local ports to use for transfers, e.g. 50000-50099 (default 49152-65535)", func(value string) (err error) {
+		portRange, err = utils.ParsePortRange(value)
+		return err
+	})
+	timeout := flag.Duration("timeout", 0, "Overall deadline for the transfer, e.g. 30s or 5m. 0 for none.")
+	retries := flag.Int("retries", 5, "Consecutive timeouts tolerated before giving up.")
+	rexmt := flag.Int("rexmt", 0, "Seconds to wait before retransmitting (1-255), negotiated with the server. 0 for the default of 5.")
+	quiet := flag.Bool("quiet", false, "Print nothing but errors: no progress and no summary.")
+	rollover := flag.String("rollover", "", "Block number following 65535 to negotiate: 0 or 1. Empty wraps to 0 without negotiation.")
+	interactive := flag.Bool("i", false, "Start an interactive shell; also the default when run without flags. A server may follow as [host [port]].")
 
 	flag.Parse()
 
+	if *interactive || flag.NFlag() == 0 {
+		sh := &shell{
+			in:        newLineReader(),
+			out:       os.Stdout,
+			mode:      strings.ToLower(*transferMode),
+			blockSize: *blockSize,
+			rexmt:     *rexmt,
+			timeout:   *timeout,
+			configure: func(cli *client.Client) {
+				cli.WindowSize = *windowSize
+				cli.Rollover = *rollover
+				cli.MaxRetries = *retries
+				cli.PortRange = portRange
+			},
+		}
+		switch {
+		case flag.NArg() > 0:
+			sh.connect(flag.Args())
+		case *remoteAddress != "":
+			sh.connect([]string{*remoteAddress})
+		}
+		sh.run()
+		return
+	}
+
 	err := validateFlags(mode, remote, remoteAddress, local)
 	if err != nil {
-		log.Fatalf("Error: %v", err)
+		log.Printf("Error: %v", err)
+		os.Exit(EXIT_USAGE)
 	}
 
 	cli := client.New(*remoteAddress)
+	cli.BlockSize = *blockSize
+	cli.WindowSize = *windowSize
+	cli.Mode = *transferMode
+	cli.Rollover = *rollover
+	cli.MaxRetries = *retries
+	cli.Timeout = time.Duration(*rexmt) * time.Second
+	cli.PortRange = portRange
+	endProgress := func() {}
+	if !*quiet {
+		cli.OnTransferSize = func(size int64) {
+			fmt.Printf("remote file size: %s\n", humanize.Bytes(uint64(size)))
+		}
+		endProgress = showProgress(cli, map[string]string{"get": "received", "put": "sent"}[*mode])
+
+		fmt.Println("host: ", *local)
+	}
 
-	fmt.Println("host: ", *local)
+	// Ctrl-C cancels the transfer, telling the server it was abandoned.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
 
-	operations := map[string]func(string, string) error{
+	operations := map[string]func(context.Context, string, string) (client.Result, error){
 		"get": cli.Get,
 		"put": cli.Put,
 	}
 
 	op := operations[*mode] // Validated safe in validateFlags.
-	err = op(*remote, *local)
+	result, err := op(ctx, *remote, *local)
 	if err != nil {
-		log.Fatal(err)
+		endProgress()
+		log.Print(err)
+		os.Exit(exitCode(err))
+	}
+	if !*quiet {
+		fmt.Printf("Transfer complete: %s in %v, %d retransmits\n", humanize.Bytes(uint64(result.Bytes)), result.Duration.Round(time.Millisecond), result.Retransmits)
+		fmt.Println("finished with success")
+	}
+}
+
+// exitCode returns the exit code reporting err.
+func exitCode(err error) int {
+	var tftpErr *client.TFTPError
+	switch {
+	case err == nil:
+		return EXIT_SUCCESS
+	case errors.Is(err, client.ErrTimeout), errors.Is(err, context.DeadlineExceeded):
+		return EXIT_TIMEOUT
+	case errors.Is(err, client.ErrCancelled):
+		return EXIT_CANCELLED
+	case errors.Is(err, client.ErrUnknownTID):
+		return EXIT_UNKNOWN_TID
+	case errors.Is(err, client.ErrProtocol):
+		return EXIT_PROTOCOL
+	case errors.Is(err, client.ErrLocalIO):
+		return EXIT_LOCAL_IO
+	case errors.As(err, &tftpErr):
+		switch tftpErr.Code {
+		case protocol.ERR_FILE_NOT_FOUND:
+			return EXIT_FILE_NOT_FOUND
+		case protocol.ERR_ACCESS_VIOLATION:
+			return EXIT_ACCESS_DENIED
+		case protocol.ERR_DISK_FULL:
+			return EXIT_DISK_FULL
+		}
+		return EXIT_SERVER_ERROR
 	}
-	fmt.Println("finished with success")
+
+	return EXIT_FAILURE
 }
 
 func validateFlags(mode, remote, remoteAddress, local *string) error {
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"tftp/internal/client"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+const (
+	// BAR_WIDTH is the number of cells in the progress bar.
+	BAR_WIDTH = 30
+	// BAR_INTERVAL is how often the bar is redrawn on a terminal.
+	BAR_INTERVAL = 100 * time.Millisecond
+	// LOG_INTERVAL is how often progress is logged when stdout is not a terminal.
+	LOG_INTERVAL = 5 * time.Second
+)
+
+// isTerminal reports whether f is a terminal rather than a file or pipe.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// showProgress makes cli report its transfers: a bar redrawn in place on
+// a terminal, or a log line every LOG_INTERVAL otherwise. The returned
+// function ends a bar left unfinished by a failed transfer, so what is
+// printed next starts on a line of its own.
+func showProgress(cli *client.Client, verb string) func() {
+	if !isTerminal(os.Stdout) {
+		cli.ProgressInterval = LOG_INTERVAL
+		cli.OnProgress = func(p client.Progress) {
+			log.Print(progressLine(verb, p))
+		}
+		return func() {}
+	}
+
+	drawn := false
+	cli.ProgressInterval = BAR_INTERVAL
+	cli.OnProgress = func(p client.Progress) {
+		drawBar(os.Stdout, p)
+		drawn = !p.Done
+	}
+	return func() {
+		if drawn {
+			fmt.Println()
+		}
+	}
+}
+
+// drawBar redraws the progress bar on the current line of w, moving to
+// the next line once the transfer is done.
+func drawBar(w io.Writer, p client.Progress) {
+	rate := humanize.Bytes(uint64(p.Rate)) + "/s"
+	if p.Total <= 0 {
+		// Without a size there is nothing to fill the bar towards.
+		fmt.Fprintf(w, "\r%10s  %10s\033[K", humanize.Bytes(uint64(p.Bytes)), rate)
+	} else {
+		fraction := min(float64(p.Bytes)/float64(p.Total), 1)
+		filled := int(fraction * BAR_WIDTH)
+		bar := strings.Repeat("=", filled) + strings.Repeat(" ", BAR_WIDTH-filled)
+		fmt.Fprintf(w, "\r[%s] %3.0f%%  %s / %s  %s  ETA %s\033[K",
+			bar, fraction*100, humanize.Bytes(uint64(p.Bytes)), humanize.Bytes(uint64(p.Total)), rate, eta(p))
+	}
+
+	if p.Done {
+		fmt.Fprintln(w)
+	}
+}
+
+// progressLine describes progress in a line suitable for a log.
+func progressLine(verb string, p client.Progress) string {
+	line := fmt.Sprintf("%s %s", verb, humanize.Bytes(uint64(p.Bytes)))
+	if p.Total > 0 {
+		line += fmt.Sprintf(" of %s (%.0f%%)", humanize.Bytes(uint64(p.Total)), float64(p.Bytes)/float64(p.Total)*100)
+	}
+	line += fmt.Sprintf(" at %s/s, %d retransmits", humanize.Bytes(uint64(p.Rate)), p.Retransmits)
+	if p.Total > p.Bytes && !p.Done {
+		line += ", ETA " + eta(p)
+	}
+
+	return line
+}
+
+// eta estimates the time left from the average rate so far.
+func eta(p client.Progress) string {
+	if p.Done {
+		return "0s"
+	}
+	if p.Rate <= 0 || p.Total <= p.Bytes {
+		return "--"
+	}
+
+	left := time.Duration(float64(p.Total-p.Bytes) / p.Rate * float64(time.Second))
+	return left.Round(time.Second).String()
+}
@@ -0,0 +1,471 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"tftp/internal/client"
+	"tftp/internal/lineedit"
+	protocol "tftp/internal/protocol/parse"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+// DEFAULT_PORT is the server port used when connect is given none.
+const DEFAULT_PORT = "69"
+
+// lineReader reads the commands typed at the shell.
+type lineReader interface {
+	ReadLine(prompt string) (string, error)
+}
+
+// plainLines reads whole lines, for input that is not a terminal or a
+// terminal that cannot be put into raw mode.
+type plainLines struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+func (p plainLines) ReadLine(prompt string) (string, error) {
+	fmt.Fprint(p.out, prompt)
+	line, err := p.in.ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// shell is the interactive client, in the style of the BSD tftp(1).
+type shell struct {
+	in  lineReader
+	out io.Writer
+
+	// host is the server as host:port, empty until connect.
+	host      string
+	mode      string
+	blockSize int
+	// rexmt is the retransmit interval in seconds, 0 for the default.
+	rexmt int
+	// timeout limits each transfer as a whole, 0 for no limit.
+	timeout time.Duration
+	verbose bool
+	trace   bool
+
+	// configure applies the settings the shell has no command for,
+	// taken from the command line.
+	configure func(*client.Client)
+}
+
+// shellCommand is a command of the shell. run returns false to end it.
+type shellCommand struct {
+	usage string
+	help  string
+	run   func(s *shell, args []string) bool
+}
+
+var shellCommands map[string]shellCommand
+
+func init() {
+	shellCommands = map[string]shellCommand{
+		"connect": {"connect host [port]", "set the server to transfer with", (*shell).connect},
+		"get":     {"get remote [local] | get file1 file2 ...", "receive files", (*shell).get},
+		"put":     {"put local [remote] | put file1 file2 ...", "send files", (*shell).put},
+		"mode":    {"mode [octet|netascii]", "set the transfer mode", (*shell).setMode},
+		"binary":  {"binary", "set the mode to octet", func(s *shell, _ []string) bool { return s.setMode([]string{protocol.MODE_OCTET}) }},
+		"ascii":   {"ascii", "set the mode to netascii", func(s *shell, _ []string) bool { return s.setMode([]string{protocol.MODE_NETASCII}) }},
+		"blksize": {"blksize [bytes]", "set the block size to negotiate, 0 for none", (*shell).setBlockSize},
+		"rexmt":   {"rexmt [seconds]", "set the per-packet retransmission timeout", (*shell).setRexmt},
+		"timeout": {"timeout [seconds]", "set the total timeout of a transfer, 0 for none", (*shell).setTimeout},
+		"verbose": {"verbose", "toggle progress and transfer summaries", (*shell).toggleVerbose},
+		"trace":   {"trace", "toggle printing every packet", (*shell).toggleTrace},
+		"status":  {"status", "show the current settings", (*shell).status},
+		"quit":    {"quit", "leave the shell", func(*shell, []string) bool { return false }},
+		"help":    {"help [command]", "describe the commands", (*shell).help},
+	}
+
+	aliases := map[string]string{"octet": "binary", "netascii": "ascii", "exit": "quit", "q": "quit", "?": "help"}
+	for alias, name := range aliases {
+		shellCommands[alias] = shellCommands[name]
+	}
+}
+
+// newLineReader returns a line editor if stdin is a terminal that
+// supports one, and reads plain lines otherwise.
+func newLineReader() lineReader {
+	if isTerminal(os.Stdin) {
+		editor, err := lineedit.NewTerminal(os.Stdin, os.Stdout)
+		if err == nil {
+			return editor
+		}
+		log.Printf("%v; line editing and history are disabled", err)
+	}
+
+	return plainLines{in: bufio.NewReader(os.Stdin), out: os.Stdout}
+}
+
+// run reads and runs commands until quit or the end of the input.
+func (s *shell) run() {
+	for {
+		line, err := s.in.ReadLine("tftp> ")
+		if errors.Is(err, lineedit.ErrInterrupted) {
+			continue
+		}
+		if err != nil {
+			if err != io.EOF {
+				fmt.Fprintln(s.out, err)
+			}
+			return
+		}
+
+		args := strings.Fields(line)
+		if len(args) == 0 {
+			continue
+		}
+
+		command, matches := lookupCommand(args[0])
+		if matches > 1 {
+			fmt.Fprintln(s.out, "?Ambiguous command")
+			continue
+		}
+		if matches == 0 {
+			fmt.Fprintln(s.out, "?Invalid command")
+			continue
+		}
+		if !command.run(s, args[1:]) {
+			return
+		}
+	}
+}
+
+func (s *shell) connect(args []string) bool {
+	if len(args) == 0 || len(args) > 2 {
+		fmt.Fprintln(s.out, "usage: connect host [port]")
+		return true
+	}
+
+	host, err := serverAddress(args[0], args[1:]...)
+	if err != nil {
+		fmt.Fprintln(s.out, err)
+		return true
+	}
+
+	s.host = host
+	return true
+}
+
+// serverAddress joins host and port, which defaults to the one in host or
+// DEFAULT_PORT. An IPv6 address may be given with or without brackets.
+func serverAddress(host string, port ...string) (string, error) {
+	if len(port) > 0 {
+		if n, err := strconv.Atoi(port[0]); err != nil || n < 1 || n > 65535 {
+			return "", fmt.Errorf("%s: bad port number", port[0])
+		}
+		return net.JoinHostPort(strings.Trim(host, "[]"), port[0]), nil
+	}
+
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host, nil
+	}
+
+	return net.JoinHostPort(strings.Trim(host, "[]"), DEFAULT_PORT), nil
+}
+
+func (s *shell) get(args []string) bool {
+	if len(args) == 0 {
+		fmt.Fprintln(s.out, "usage: get remote [local] | get file1 file2 ...")
+		return true
+	}
+	if !s.connected() {
+		return true
+	}
+
+	// "get remote local" names the local file; otherwise each file is
+	// saved under its own name in the current directory.
+	if len(args) == 2 {
+		s.transfer("get", args[0], args[1])
+		return true
+	}
+	for _, remote := range args {
+		s.transfer("get", remote, filepath.Base(remote))
+	}
+
+	return true
+}
+
+func (s *shell) put(args []string) bool {
+	if len(args) == 0 {
+		fmt.Fprintln(s.out, "usage: put local [remote] | put file1 file2 ...")
+		return true
+	}
+	if !s.connected() {
+		return true
+	}
+
+	if len(args) == 2 {
+		s.transfer("put", args[1], args[0])
+		return true
+	}
+	for _, local := range args {
+		s.transfer("put", filepath.Base(local), local)
+	}
+
+	return true
+}
+
+func (s *shell) connected() bool {
+	if s.host == "" {
+		fmt.Fprintln(s.out, "No target machine specified; use connect first.")
+		return false
+	}
+
+	return true
+}
+
+// transfer runs one get or put. Ctrl-C cancels it, returning to the prompt.
+func (s *shell) transfer(op, remote, local string) {
+	cli := s.client()
+	endProgress := func() {}
+	if s.verbose {
+		endProgress = showProgress(cli, map[string]string{"get": "received", "put": "sent"}[op])
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+
+	transfer := cli.Get
+	if op == "put" {
+		transfer = cli.Put
+	}
+	result, err := transfer(ctx, remote, local)
+	if err != nil {
+		endProgress()
+		var tftpErr *client.TFTPError
+		if errors.As(err, &tftpErr) {
+			fmt.Fprintf(s.out, "Error code %d: %s\n", tftpErr.Code, tftpErr.Message)
+		} else {
+			fmt.Fprintf(s.out, "%s %s: %v\n", op, remote, err)
+		}
+		return
+	}
+
+	if s.verbose {
+		verb := map[string]string{"get": "Received", "put": "Sent"}[op]
+		rate := float64(result.Bytes) / max(result.Duration.Seconds(), time.Millisecond.Seconds())
+		fmt.Fprintf(s.out, "%s %s in %v (%s/s), %d retransmits\n", verb, humanize.Bytes(uint64(result.Bytes)),
+			result.Duration.Round(time.Millisecond), humanize.Bytes(uint64(rate)), result.Retransmits)
+	}
+}
+
+// client returns a client for the current server and settings.
+func (s *shell) client() *client.Client {
+	cli := client.New(s.host)
+	s.configure(cli)
+	cli.Mode = s.mode
+	cli.BlockSize = s.blockSize
+	cli.Timeout = time.Duration(s.rexmt) * time.Second
+	if s.trace {
+		cli.Trace = func(sent bool, packet []byte) {
+			direction := "received"
+			if sent {
+				direction = "sent"
+			}
+			fmt.Fprintf(s.out, "%s %s\n", direction, describePacket(packet))
+		}
+	}
+
+	return cli
+}
+
+// describePacket formats a packet for trace.
+func describePacket(packet []byte) string {
+	parsed, err := protocol.Parse(packet)
+	if err != nil {
+		return fmt.Sprintf("malformed packet of %d bytes", len(packet))
+	}
+
+	switch p := parsed.(type) {
+	case protocol.ReadRequest:
+		return fmt.Sprintf("RRQ <file=%s, mode=%s%s>", p.Filename, p.Mode, describeOptions(p.Options))
+	case protocol.WriteRequest:
+		return fmt.Sprintf("WRQ <file=%s, mode=%s%s>", p.Filename, p.Mode, describeOptions(p.Options))
+	case protocol.Data:
+		return fmt.Sprintf("DATA <block=%d, %d bytes>", p.BlockNumber, len(p.Data))
+	case protocol.Ack:
+		return fmt.Sprintf("ACK <block=%d>", p.BlockNumber)
+	case protocol.Error:
+		return fmt.Sprintf("ERROR <code=%d, msg=%s>", p.ErrorCode, p.ErrorMsg)
+	case protocol.OptionAck:
+		return fmt.Sprintf("OACK <%s>", strings.TrimPrefix(describeOptions(p.Options), ", "))
+	}
+
+	return parsed.OpCode().String()
+}
+
+// describeOptions formats options as ", name=value" pairs in name order.
+func describeOptions(options map[string]string) string {
+	names := make([]string, 0, len(options))
+	for name := range options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&b, ", %s=%s", name, options[name])
+	}
+	return b.String()
+}
+
+func (s *shell) setMode(args []string) bool {
+	if len(args) == 0 {
+		fmt.Fprintf(s.out, "Using %s mode to transfer files.\n", s.mode)
+		return true
+	}
+
+	switch strings.ToLower(args[0]) {
+	case protocol.MODE_OCTET, "binary":
+		s.mode = protocol.MODE_OCTET
+	case protocol.MODE_NETASCII, "ascii":
+		s.mode = protocol.MODE_NETASCII
+	default:
+		fmt.Fprintf(s.out, "%s: unknown mode\n", args[0])
+	}
+
+	return true
+}
+
+func (s *shell) setBlockSize(args []string) bool {
+	s.setNumber(args, "blksize", &s.blockSize, func(n int) bool {
+		return n == 0 || n >= protocol.MIN_BLOCK_SIZE && n <= protocol.MAX_BLOCK_SIZE
+	})
+	return true
+}
+
+func (s *shell) setRexmt(args []string) bool {
+	s.setNumber(args, "rexmt", &s.rexmt, func(n int) bool {
+		return n == 0 || n >= protocol.MIN_TIMEOUT && n <= protocol.MAX_TIMEOUT
+	})
+	return true
+}
+
+func (s *shell) setTimeout(args []string) bool {
+	seconds := int(s.timeout / time.Second)
+	s.setNumber(args, "timeout", &seconds, func(n int) bool { return n >= 0 })
+	s.timeout = time.Duration(seconds) * time.Second
+	return true
+}
+
+// setNumber shows the setting name, or sets it to args[0] if valid allows it.
+func (s *shell) setNumber(args []string, name string, setting *int, valid func(int) bool) {
+	if len(args) == 0 {
+		fmt.Fprintf(s.out, "%s is %d\n", name, *setting)
+		return
+	}
+
+	n, err := strconv.Atoi(args[0])
+	if err != nil || !valid(n) {
+		fmt.Fprintf(s.out, "%s: bad value %s\n", name, args[0])
+		return
+	}
+
+	*setting = n
+}
+
+func (s *shell) toggleVerbose([]string) bool {
+	s.verbose = !s.verbose
+	fmt.Fprintf(s.out, "Verbose mode %s.\n", onOff(s.verbose))
+	return true
+}
+
+func (s *shell) toggleTrace([]string) bool {
+	s.trace = !s.trace
+	fmt.Fprintf(s.out, "Packet tracing %s.\n", onOff(s.trace))
+	return true
+}
+
+func (s *shell) status([]string) bool {
+	if s.host == "" {
+		fmt.Fprintln(s.out, "Not connected.")
+	} else {
+		fmt.Fprintf(s.out, "Connected to %s.\n", s.host)
+	}
+	fmt.Fprintf(s.out, "Mode: %s Verbose: %s Tracing: %s\n", s.mode, onOff(s.verbose), onOff(s.trace))
+	fmt.Fprintf(s.out, "Rexmt-interval: %s Max-timeout: %s Blksize: %s\n",
+		orDefault(s.rexmt, "default"), orDefault(int(s.timeout/time.Second), "none"), orDefault(s.blockSize, "default"))
+	return true
+}
+
+func (s *shell) help(args []string) bool {
+	if len(args) > 0 {
+		command, matches := lookupCommand(args[0])
+		if matches != 1 {
+			fmt.Fprintf(s.out, "?Invalid help command %s\n", args[0])
+			return true
+		}
+		fmt.Fprintf(s.out, "%s\t%s\n", command.usage, command.help)
+		return true
+	}
+
+	names := []string{"connect", "get", "put", "mode", "binary", "ascii", "blksize", "rexmt", "timeout", "verbose", "trace", "status", "quit", "help"}
+	fmt.Fprintln(s.out, "Commands may be abbreviated to any unique prefix. Commands are:")
+	for _, name := range names {
+		fmt.Fprintf(s.out, "%-10s%s\n", name, shellCommands[name].help)
+	}
+	return true
+}
+
+func onOff(on bool) string {
+	if on {
+		return "on"
+	}
+
+	return "off"
+}
+
+// orDefault formats a numeric setting, or fallback when it is unset.
+func orDefault(n int, fallback string) string {
+	if n == 0 {
+		return fallback
+	}
+
+	return strconv.Itoa(n)
+}
+
+// lookupCommand finds the command name names, or the one it is a prefix
+// of, and returns how many commands matched; only 1 is a usable match.
+func lookupCommand(name string) (shellCommand, int) {
+	name = strings.ToLower(name)
+	if command, ok := shellCommands[name]; ok {
+		return command, 1
+	}
+
+	var matches []string
+	for candidate := range shellCommands {
+		if strings.HasPrefix(candidate, name) {
+			matches = append(matches, candidate)
+		}
+	}
+	if len(matches) != 1 {
+		return shellCommand{}, len(matches)
+	}
+
+	return shellCommands[matches[0]], 1
+}
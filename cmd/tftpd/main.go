@@ -1,19 +1,148 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
+	"fmt"
 	"log"
+	"net"
+	"os"
+	"os/signal"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
 	"tftp/internal/server"
+	"tftp/internal/utils"
+	"time"
+
+	"github.com/dustin/go-humanize"
 )
 
 func main() {
-	port := flag.Int("port", 69, "Port to listen on")
+	port := flag.Int("port", 69, "Port to listen on, for -listen addresses without one")
+	var listen []string
+	flag.Func("listen", "Address to listen on, e.g. 0.0.0.0, [::], 192.0.2.1:6969 or [fe80::1%eth0] (repeatable, default: 127.0.0.1 only). Anyone who can reach these addresses can read -root and upload as -write-policy allows", func(addr string) error {
+		listen = append(listen, addr)
+		return nil
+	})
 	root := flag.String("root", "./tftp-root", "Root directory for file transfers")
+	maxUploadSize := flag.String("max-upload-size", "", "Largest accepted upload, e.g. 512MB (default: no limit beyond free disk space)")
+	drainTimeout := flag.Duration("drain-timeout", 30*time.Second, "How long to let running transfers finish on SIGINT/SIGTERM before cancelling them")
+	writePolicy := flag.String("write-policy", "allow-all", "Which uploads to accept: allow-all, read-only, create-only (never overwrite) or overwrite-only (never create). TFTP has no authentication, so allow-all lets any client that can reach -listen create and overwrite files under -root")
+	var writeAllow, writeDeny []string
+	flag.Func("write-allow", "Only accept uploads to filenames matching this glob, e.g. 'backups/*.cfg' (repeatable)", globFlag(&writeAllow))
+	flag.Func("write-deny", "Refuse uploads to filenames matching this glob, even if allowed (repeatable)", globFlag(&writeDeny))
+	aclFile := flag.String("acl", "", "File of allow/deny rules by client network, request and path (default: allow everyone)")
+	var portRange utils.PortRange
+	flag.Func("port-range", "Ports for transfer sockets, e.g. 50000-50099; requests beyond its size are refused as busy (default 49152-65535)", func(value string) (err error) {
+		portRange, err = utils.ParsePortRange(value)
+		return err
+	})
+	rollover := flag.Uint("rollover", 0, "Block number following 65535 unless a client negotiates it: 0 or 1")
 	flag.Parse()
 
-	srv := server.New(*port, *root)
-	log.Printf("Starting TFTP server on port %d...", *port)
-	if err := srv.ListenAndServe(); err != nil {
+	backend, err := server.NewLocalBackend(*root)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer backend.Close()
+
+	addrs, err := listenAddrs(listen, *port)
+	if err != nil {
+		log.Fatalf("invalid -listen: %v", err)
+	}
+
+	srv := server.New(addrs, backend)
+	if *rollover > 1 {
+		log.Fatalf("invalid -rollover %d: must be 0 or 1", *rollover)
+	}
+	srv.Rollover = uint16(*rollover)
+	srv.PortRange = portRange
+	srv.WritePolicy, err = server.ParseWritePolicy(*writePolicy)
+	if err != nil {
+		log.Fatalf("invalid -write-policy: %v", err)
+	}
+	if *aclFile != "" {
+		srv.ACL, err = server.LoadACL(*aclFile)
+		if err != nil {
+			log.Fatalf("invalid -acl: %v", err)
+		}
+	}
+	srv.WriteAllow = writeAllow
+	srv.WriteDeny = writeDeny
+	if *maxUploadSize != "" {
+		size, err := humanize.ParseBytes(*maxUploadSize)
+		if err != nil {
+			log.Fatalf("invalid -max-upload-size: %v", err)
+		}
+		srv.MaxUploadSize = int64(size)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	served := make(chan error, 1)
+	go func() {
+		log.Printf("Starting TFTP server on %s...", strings.Join(addrs, ", "))
+		served <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-served:
 		log.Fatal(err)
+	case <-ctx.Done():
+		// A second signal kills the process without waiting.
+		stop()
+	}
+
+	log.Printf("Shutting down, waiting up to %v for %d transfer(s)...", *drainTimeout, srv.ActiveSessions())
+	drainCtx, cancel := context.WithTimeout(context.Background(), *drainTimeout)
+	defer cancel()
+	if err := srv.Shutdown(drainCtx); err != nil {
+		log.Printf("cancelled unfinished transfers: %v", err)
+	}
+
+	if err := <-served; err != nil && !errors.Is(err, server.ErrServerClosed) {
+		log.Print(err)
+	}
+	log.Print("Server stopped")
+}
+
+// listenAddrs gives each address without a port the default one. No
+// addresses means the loopback interface, so the daemon is only reachable
+// from the network once told where to listen.
+func listenAddrs(addrs []string, port int) ([]string, error) {
+	if len(addrs) == 0 {
+		return []string{net.JoinHostPort("127.0.0.1", strconv.Itoa(port))}, nil
+	}
+
+	withPorts := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		if _, _, err := net.SplitHostPort(addr); err == nil {
+			withPorts = append(withPorts, addr)
+			continue
+		}
+
+		// A bare IPv6 address may be bracketed, as in a URL.
+		host := strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+		if host == "" {
+			return nil, fmt.Errorf("empty address")
+		}
+		withPorts = append(withPorts, net.JoinHostPort(host, strconv.Itoa(port)))
+	}
+
+	return withPorts, nil
+}
+
+// globFlag returns a flag.Func that appends each valid path.Match pattern to patterns.
+func globFlag(patterns *[]string) func(string) error {
+	return func(pattern string) error {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return err
+		}
+		*patterns = append(*patterns, pattern)
+		return nil
 	}
 }
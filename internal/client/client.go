@@ -2,20 +2,79 @@ package client
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"io"
 	"net"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"tftp/internal/netascii"
 	protocol "tftp/internal/protocol/parse"
+	"tftp/internal/transfer"
 	"tftp/internal/utils"
 	"time"
-
-	humanize "github.com/dustin/go-humanize"
 )
 
 type Client struct {
 	serverAddr string
+
+	mu    sync.Mutex
+	ports *utils.PortAllocator
+
+	// Options are RFC 2347 options sent with every request.
+	// The server acknowledges the subset it accepts in an OACK.
+	Options map[string]string
+
+	// BlockSize is the blksize (RFC 2348) to request, from 8 to 65464.
+	// Zero uses the RFC 1350 default of 512 bytes without negotiation.
+	BlockSize int
+
+	// WindowSize is the windowsize (RFC 7440) to request, from 1 to 64.
+	// RFC 7440 allows up to 65535, but a window is buffered in memory.
+	// Zero uses RFC 1350 lock-step without negotiation.
+	WindowSize int
+
+	// Timeout is the per-packet retransmit interval, requested from the
+	// server with the timeout option (RFC 2349) in whole seconds from 1 to 255.
+	// Zero keeps the default of 5 seconds without negotiation.
+	Timeout time.Duration
+
+	// MaxRetries is how many consecutive timeouts a transfer tolerates
+	// before giving up. Zero keeps the default of 5.
+	MaxRetries int
+
+	// Rollover is the block number that follows 65535, for files larger
+	// than 32 MB at the default block size. Empty wraps to 0 without
+	// negotiation; "0" or "1" is requested with the rollover option.
+	Rollover string
+
+	// Mode is the transfer mode, protocol.MODE_OCTET or protocol.MODE_NETASCII.
+	// Empty means octet. In netascii mode line endings are translated.
+	Mode string
+
+	// PortRange is where the client's sockets take their ports, its TIDs.
+	// The zero value means utils.DEFAULT_PORT_RANGE.
+	PortRange utils.PortRange
+
+	// OnTransferSize, if set, makes Get request the file's size with the
+	// tsize option (RFC 2349) and is called with it before any data arrives.
+	// It is not called if the server does not report a size.
+	OnTransferSize func(size int64)
+
+	// OnProgress, if set, is called as a transfer runs: after each block,
+	// or at most once per ProgressInterval if that is set, and a final time
+	// with Done set once the transfer completes. Get and GetTo request the
+	// file's size with tsize so Progress.Total can be reported.
+	OnProgress func(Progress)
+
+	// ProgressInterval is the least time between calls of OnProgress.
+	// Zero calls it after every block.
+	ProgressInterval time.Duration
+
+	// Trace, if set, is called with every packet sent to or received from
+	// the server, for debugging.
+	Trace func(sent bool, packet []byte)
 }
 
 const (
@@ -26,323 +85,312 @@ func New(serverAddr string) *Client {
 	return &Client{serverAddr: serverAddr}
 }
 
-func (c *Client) Get(remote, local string) error {
-	requestingTID := utils.GenerateTID()
-	ctx := context.Background()
-	result := make(chan error, 1)
-
-	go get(ctx, result, c.serverAddr, requestingTID, remote, local)
+// Result describes a completed transfer.
+type Result struct {
+	Bytes       int64         // Bytes of file data sent or received on the wire.
+	Blocks      uint64        // DATA blocks moved, counting each block once.
+	Retransmits int           // Packets sent again after a timeout.
+	Duration    time.Duration // Time from the request to the last packet.
+}
 
-	select {
-	case err := <-result:
-		return err
-	case <-ctx.Done():
-		return fmt.Errorf("operation timed out: %w", ctx.Err())
+func newResult(stats transfer.Stats, started time.Time) Result {
+	return Result{
+		Bytes:       stats.Bytes,
+		Blocks:      stats.Blocks,
+		Retransmits: stats.Retransmits,
+		Duration:    time.Since(started),
 	}
 }
 
-func (c *Client) Put(remote, local string) error {
-	requestingTID := utils.GenerateTID()
-	ctx := context.Background()
-	result := make(chan error, 1)
-	go put(ctx, result, c.serverAddr, requestingTID, remote, local)
-
-	select {
-	case err := <-result:
-		return err
-	case <-ctx.Done():
-		return fmt.Errorf("operation timed out: %w", ctx.Err())
+// Get downloads remote into the file at local. The file is only replaced
+// once the whole transfer has arrived.
+func (c *Client) Get(ctx context.Context, remote, local string) (Result, error) {
+	// Download next to local and rename over it only once complete,
+	// so a failed transfer leaves an existing file untouched.
+	file, err := os.CreateTemp(filepath.Dir(local), "."+filepath.Base(local)+".*.tmp")
+	if err != nil {
+		return Result{}, localError(err)
 	}
-}
+	defer func() {
+		// A no-op once the file has been renamed.
+		file.Close()
+		os.Remove(file.Name())
+	}()
 
-func makeConn(ctx context.Context, result chan error, serverAddr string, requestingTID int) (*net.UDPConn, *net.UDPAddr) {
-	raddr, err := net.ResolveUDPAddr("udp", serverAddr)
+	result, err := c.GetTo(ctx, remote, file)
 	if err != nil {
-		result <- errors.New("failed to resolve remote UDP address")
-		return nil, nil
+		return result, err
 	}
 
-	localAddr, err := net.ResolveUDPAddr("udp", fmt.Sprintf("localhost:%d", requestingTID))
+	return result, localError(commitFile(file, local))
+}
+
+// Put uploads the file at local to remote, declaring its size with tsize
+// in octet mode.
+func (c *Client) Put(ctx context.Context, remote, local string) (Result, error) {
+	file, err := os.Open(local)
 	if err != nil {
-		result <- errors.New("failed to resolve local UDP address")
-		return nil, nil
+		return Result{}, localError(err)
 	}
+	defer file.Close()
 
-	// We must use ListenUDP and not DialUDP since DialUDP creates a 'connected'
-	// socket analogous to the connect(2) syscall.
-	// However, TFTP requires 1) send on port 69, and 2) continue on port TID,
-	// but a connected socket is a socket where the remote address is bound to the socket itself.
-	// Therefore, switching ports wouldn't work.
-	conn, err := net.ListenUDP("udp", localAddr)
+	// Declaring the size up front lets the server refuse an upload it has no room for.
+	info, err := file.Stat()
 	if err != nil {
-		result <- err
-		return nil, nil
+		return Result{}, localError(err)
 	}
 
-	// defer connection close in caller.
+	return c.PutFrom(ctx, remote, file, info.Size())
+}
+
+// GetTo downloads remote, writing its contents to w as they arrive.
+// If the transfer fails, w may hold part of the file.
+//
+// Once ctx is done the transfer stops: the server is sent an ERROR, the
+// socket is closed, and the error returned wraps ErrCancelled and
+// ctx.Err(). The same applies to every method taking a context. An ERROR
+// from the server is returned as a *TFTPError; ErrTimeout and the other
+// Err variables cover the rest of the ways a transfer can fail.
+func (c *Client) GetTo(ctx context.Context, remote string, w io.Writer) (Result, error) {
+	if err := c.validate(); err != nil {
+		return Result{}, err
+	}
 
-	deadline, ok := ctx.Deadline()
-	if ok {
-		conn.SetDeadline(deadline)
+	// A tsize of 0 asks the server to report the file's size.
+	transferSize := int64(-1)
+	if c.OnTransferSize != nil || c.OnProgress != nil {
+		transferSize = 0
 	}
 
-	return conn, raddr
+	return c.get(ctx, remote, w, c.requestOptions(transferSize))
 }
 
-func put(ctx context.Context, result chan error, serverAddr string, requestingTID int, remotePath, localPath string) {
-	conn, raddr := makeConn(ctx, result, serverAddr, requestingTID)
-	if conn == nil || raddr == nil {
-		result <- errors.New("failed to make UDP connection")
-		return
+// PutFrom uploads everything read from r to remote. A non-negative sizeHint
+// is declared to the server with tsize, so it can refuse an upload it has
+// no room for; pass -1 when the size is not known. In netascii mode no
+// tsize is sent, as the converted data differs in size from r.
+func (c *Client) PutFrom(ctx context.Context, remote string, r io.Reader, sizeHint int64) (Result, error) {
+	if err := c.validate(); err != nil {
+		return Result{}, err
 	}
-	defer conn.Close()
 
-	file, err := os.Open(localPath)
-	if err != nil {
-		result <- err
-		return
+	if c.mode() == protocol.MODE_NETASCII {
+		sizeHint = -1
 	}
-	defer file.Close()
-
-	maxRetries := 5
-	timeout := 5 * time.Second
-	var serverTIDAddr *net.UDPAddr
 
-	wrq := protocol.WriteRequest{Filename: remotePath, Mode: "netascii"}
+	return c.put(ctx, remote, r, sizeHint, c.requestOptions(sizeHint))
+}
 
-	for retries := 0; retries < maxRetries; retries++ {
-		_, err := conn.WriteToUDP(wrq.ToBinary(), raddr)
-		if err != nil {
-			continue
-		}
+func (c *Client) validate() error {
+	if c.BlockSize != 0 && (c.BlockSize < protocol.MIN_BLOCK_SIZE || c.BlockSize > protocol.MAX_BLOCK_SIZE) {
+		return fmt.Errorf("block size %d is outside [%d, %d]", c.BlockSize, protocol.MIN_BLOCK_SIZE, protocol.MAX_BLOCK_SIZE)
+	}
 
-		buffer := make([]byte, TFTP_MAX_DATAGRAM_LENGTH)
-		conn.SetReadDeadline(time.Now().Add(timeout))
-		n, addr, err := conn.ReadFromUDP(buffer)
-		if err != nil {
-			continue
-		}
+	if c.WindowSize != 0 && (c.WindowSize < protocol.MIN_WINDOW_SIZE || c.WindowSize > protocol.WINDOW_SIZE_LIMIT) {
+		return fmt.Errorf("window size %d is outside [%d, %d]", c.WindowSize, protocol.MIN_WINDOW_SIZE, protocol.WINDOW_SIZE_LIMIT)
+	}
 
-		packet, err := protocol.Parse(buffer[:n])
-		if err != nil {
-			result <- err
-			return
-		}
+	if mode := c.mode(); mode != protocol.MODE_OCTET && mode != protocol.MODE_NETASCII {
+		return fmt.Errorf("transfer mode %s is not supported", c.Mode)
+	}
 
-		if packet.OpCode() == protocol.ERROR {
-			errorPacket, _ := packet.(protocol.Error)
-			result <- fmt.Errorf("server error %d: %s", errorPacket.ErrorCode, errorPacket.ErrorMsg)
-			return
-		}
+	if c.MaxRetries < 0 {
+		return fmt.Errorf("max retries %d must not be negative", c.MaxRetries)
+	}
 
-		if packet.OpCode() != protocol.ACK {
-			continue
-		}
+	if c.Rollover != "" && c.Rollover != "0" && c.Rollover != "1" {
+		return fmt.Errorf("rollover %q must be 0 or 1", c.Rollover)
+	}
 
-		ack, ok := packet.(protocol.Ack)
-		if !ok || ack.BlockNumber != 0 {
-			continue
+	if c.PortRange != (utils.PortRange{}) {
+		if err := c.PortRange.Validate(); err != nil {
+			return err
 		}
-
-		serverTIDAddr = addr
-		break
 	}
 
-	if serverTIDAddr == nil {
-		result <- errors.New("failed to receive ACK 0 from server")
-		return
+	seconds := c.Timeout / time.Second
+	if c.Timeout != 0 && (c.Timeout%time.Second != 0 || seconds < protocol.MIN_TIMEOUT || seconds > protocol.MAX_TIMEOUT) {
+		return fmt.Errorf("timeout %v must be whole seconds in [%d, %d]", c.Timeout, protocol.MIN_TIMEOUT, protocol.MAX_TIMEOUT)
 	}
 
-	blockNum := uint16(1)
-	offset := int64(0)
+	return nil
+}
 
-	for {
-		buf := make([]byte, TFTP_MAX_DATAGRAM_LENGTH)
-		n, err := file.ReadAt(buf, offset)
-		if err != nil && err != io.EOF {
-			result <- err
-			return
-		}
+// makeConn opens the socket for a transfer with serverAddr on a port from
+// PortRange, the client's TID. The returned function closes it.
+func (c *Client) makeConn(ctx context.Context, serverAddr string) (*net.UDPConn, *net.UDPAddr, func(), error) {
+	// Nothing has been sent yet, so there is no one to tell.
+	if err := ctx.Err(); err != nil {
+		return nil, nil, nil, fmt.Errorf("%w: %w", ErrCancelled, err)
+	}
 
-		dataPacket := protocol.Data{BlockNumber: blockNum, Data: buf[:n]}
+	raddr, err := net.ResolveUDPAddr("udp", serverAddr)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to resolve remote UDP address: %w", err)
+	}
 
-		// Send DATA with retries
-		for retries := 0; retries < maxRetries; retries++ {
-			_, err := conn.WriteToUDP(dataPacket.ToBinary(), serverTIDAddr)
-			if err != nil {
-				continue
-			}
+	// We must use ListenUDP and not DialUDP since DialUDP creates a 'connected'
+	// socket analogous to the connect(2) syscall.
+	// However, TFTP requires 1) send on port 69, and 2) continue on port TID,
+	// but a connected socket is a socket where the remote address is bound to the socket itself.
+	// Therefore, switching ports wouldn't work.
+	conn, release, err := c.portAllocator().Listen(&net.UDPAddr{}, raddr)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to make UDP connection: %w", err)
+	}
 
-			buffer := make([]byte, TFTP_MAX_DATAGRAM_LENGTH)
-			conn.SetReadDeadline(time.Now().Add(timeout))
-			ackN, _, err := conn.ReadFromUDP(buffer)
-			if err != nil {
-				continue
-			}
+	// defer connection close in caller.
+	// No deadline is set from ctx: the transfer watches ctx itself, and must
+	// still be able to send the server an ERROR once ctx is done.
 
-			packet, err := protocol.Parse(buffer[:ackN])
-			if err != nil {
-				result <- err
-				return
-			}
+	return conn, raddr, release, nil
+}
 
-			if packet.OpCode() == protocol.ERROR {
-				errorPacket, _ := packet.(protocol.Error)
-				result <- fmt.Errorf("server error %d: %s", errorPacket.ErrorCode, errorPacket.ErrorMsg)
-				return
-			}
+// portAllocator returns the allocator for PortRange, created on first use.
+func (c *Client) portAllocator() *utils.PortAllocator {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-			if packet.OpCode() != protocol.ACK {
-				continue
-			}
+	if c.ports == nil {
+		c.ports = utils.NewPortAllocator(c.PortRange)
+	}
+	return c.ports
+}
 
-			ack, ok := packet.(protocol.Ack)
-			if !ok || ack.BlockNumber != blockNum {
-				continue
-			}
+func (c *Client) mode() string {
+	if c.Mode == "" {
+		return protocol.MODE_OCTET
+	}
 
-			break
-		}
+	return strings.ToLower(c.Mode)
+}
 
-		if n < TFTP_MAX_DATAGRAM_LENGTH || err == io.EOF {
-			fmt.Println("Transfer complete")
-			result <- nil
-			return
-		}
+// config returns the transfer parameters to use until the server's OACK says otherwise.
+func (c *Client) config() transfer.Config {
+	config := transfer.DefaultConfig()
+	if c.Timeout != 0 {
+		config.Timeout = c.Timeout
+	}
 
-		blockNum++
-		offset += int64(n)
+	if c.MaxRetries != 0 {
+		config.MaxRetries = c.MaxRetries
 	}
+
+	// Rollover stays 0 unless the server echoes the option: one that
+	// ignores it wraps to 0 like any RFC 1350 server.
+	return config
 }
 
-func get(ctx context.Context, result chan error, serverAddr string, requestingTID int, remotePath, localPath string) {
-	conn, raddr := makeConn(ctx, result, serverAddr, requestingTID)
-	if conn == nil || raddr == nil {
-		result <- errors.New("failed to make UDP connection")
-		return
+// commitFile syncs a completed download and renames it to path.
+func commitFile(file *os.File, path string) error {
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
 	}
-	defer conn.Close()
 
-	file, err := os.Create(localPath)
-	if err != nil {
-		result <- err
-		return
+	// CreateTemp makes the file private; keep the permissions of the file
+	// being replaced, or use the usual ones for a new file.
+	mode := os.FileMode(0o644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
 	}
-	defer file.Close()
+	if err := os.Chmod(file.Name(), mode); err != nil {
+		return err
+	}
+
+	return os.Rename(file.Name(), path)
+}
 
-	rrq := protocol.ReadRequest{Filename: remotePath, Mode: "netascii"}
-	_, err = conn.WriteToUDP(rrq.ToBinary(), raddr)
+func (c *Client) put(ctx context.Context, remotePath string, r io.Reader, size int64, options map[string]string) (Result, error) {
+	started := time.Now()
+	progress := c.newProgress(started, max(size, -1))
+	conn, raddr, release, err := c.makeConn(ctx, c.serverAddr)
 	if err != nil {
-		result <- err
-		return
+		return Result{}, err
 	}
+	defer release()
 
-	var fileData []byte
-	expectedBlockNum := uint16(1)
-	maxRetries := 5
-	timeout := 5 * time.Second
-	var serverTIDAddr *net.UDPAddr
+	r = localReader{r}
+	if c.mode() == protocol.MODE_NETASCII {
+		r = netascii.NewReader(r)
+	}
 
-	for {
-		retries := 0
-		var dataPacket protocol.Data
+	wrq := protocol.WriteRequest{Filename: remotePath, Mode: c.mode(), Options: options}
+	config := c.config()
+	sender := transfer.Sender{
+		Config:  config,
+		Request: wrq.ToBinary(),
+		// An OACK takes the place of ACK 0 when the server accepted options.
+		OnOptionAck: func(oack protocol.OptionAck) (transfer.Config, error) {
+			return checkOptionAck(options, oack, config)
+		},
+		OnBlock: progress.onBlock(),
+	}
 
-		for retries < maxRetries {
-			buffer := make([]byte, 1024)
-			conn.SetReadDeadline(time.Now().Add(timeout))
-			n, addr, err := conn.ReadFromUDP(buffer)
+	stats, err := sender.Send(ctx, c.transferConn(conn, raddr), r)
+	if err != nil {
+		return newResult(stats, started), clientError(err)
+	}
 
-			if err != nil {
-				retries++
-				// If we've already received data, resend the last ACK.
-				if expectedBlockNum > 1 {
-					ackPacket := protocol.Ack{BlockNumber: expectedBlockNum - 1}
-					conn.WriteToUDP(ackPacket.ToBinary(), serverTIDAddr)
-				}
-				continue
-			}
+	progress.done(stats)
+	return newResult(stats, started), nil
+}
 
-			if serverTIDAddr == nil {
-				serverTIDAddr = addr
-			}
+func (c *Client) get(ctx context.Context, remotePath string, w io.Writer, options map[string]string) (Result, error) {
+	started := time.Now()
+	progress := c.newProgress(started, -1)
+	conn, raddr, release, err := c.makeConn(ctx, c.serverAddr)
+	if err != nil {
+		return Result{}, err
+	}
+	defer release()
+
+	w = localWriter{w}
+	var decoder io.WriteCloser
+	if c.mode() == protocol.MODE_NETASCII {
+		decoder = netascii.NewWriter(w)
+		w = decoder
+	}
 
-			packet, err := protocol.Parse(buffer[:n])
+	rrq := protocol.ReadRequest{Filename: remotePath, Mode: c.mode(), Options: options}
+	config := c.config()
+	receiver := transfer.Receiver{
+		Config:  config,
+		Request: rrq.ToBinary(),
+		// The server accepted some of our options; the receiver confirms them with ACK 0.
+		OnOptionAck: func(oack protocol.OptionAck) (transfer.Config, error) {
+			config, err := checkOptionAck(options, oack, config)
 			if err != nil {
-				result <- fmt.Errorf("failed to parse packet: %w", err)
-				return
+				return config, err
 			}
 
-			// Check if it's an ERROR packet.
-			if packet.OpCode() == protocol.ERROR {
-				errorPacket, ok := packet.(protocol.Error)
-				if ok {
-					result <- fmt.Errorf("server error %d: %s", errorPacket.ErrorCode, errorPacket.ErrorMsg)
-				} else {
-					result <- errors.New("received error packet from server")
+			if size, ok := ackedTransferSize(oack); ok {
+				if c.OnTransferSize != nil {
+					c.OnTransferSize(size)
 				}
-				return
-			}
-
-			if packet.OpCode() != protocol.DATA {
-				retries++
-				continue
-			}
-
-			data, ok := packet.(protocol.Data)
-			if !ok {
-				retries++
-				continue
-			}
-
-			// Handle retry if the server didn't receive our last ACK.
-			if data.BlockNumber != expectedBlockNum {
-				if data.BlockNumber == expectedBlockNum-1 {
-					ackPacket := protocol.Ack{BlockNumber: data.BlockNumber}
-					conn.WriteToUDP(ackPacket.ToBinary(), serverTIDAddr)
+				if progress != nil && c.mode() != protocol.MODE_NETASCII {
+					progress.total = size
 				}
-				continue
 			}
+			return config, nil
+		},
+		OnBlock: progress.onBlock(),
+	}
 
-			dataPacket = data
-			break
-		}
-
-		if retries >= maxRetries {
-			result <- fmt.Errorf("max retries reached for block %d", expectedBlockNum)
-			return
-		}
-
-		fileData = append(fileData, dataPacket.Data...)
-
-		n, err := file.Write(dataPacket.Data)
-		if n != len(dataPacket.Data) {
-			result <- fmt.Errorf("wrote incomplete data into file, aborting")
-			return
-		}
-		if err != nil {
-			retries++
-			continue
-		}
-
-		// Send ACK.
-		ackPacket := protocol.Ack{BlockNumber: expectedBlockNum}
-		_, err = conn.WriteToUDP(ackPacket.ToBinary(), serverTIDAddr)
-		if err != nil {
-			result <- fmt.Errorf("failed to send ACK: %w", err)
-			return
-		}
+	stats, err := receiver.Receive(ctx, c.transferConn(conn, raddr), w)
+	result := newResult(stats, started)
+	if err != nil {
+		return result, clientError(err)
+	}
 
-		// Check if this was the last block.
-		if len(dataPacket.Data) < TFTP_MAX_DATAGRAM_LENGTH {
-			// Transfer complete.
-			break
+	if decoder != nil {
+		if err := decoder.Close(); err != nil {
+			return result, err
 		}
-
-		expectedBlockNum++
 	}
 
-	// TODO: Write fileData to localPath.
-	fmt.Printf("Transfer complete: received %s\n", humanize.Bytes(uint64(len(fileData))))
-
-	result <- nil
+	progress.done(stats)
+	return result, nil
 }
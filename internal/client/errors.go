@@ -0,0 +1,99 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	protocol "tftp/internal/protocol/parse"
+	"tftp/internal/transfer"
+)
+
+// TFTPError is returned when the server ends a transfer with an ERROR
+// packet. Code is one of the protocol.ERR_* codes.
+type TFTPError struct {
+	Code    uint16
+	Message string
+}
+
+func (e *TFTPError) Error() string {
+	return fmt.Sprintf("server error %d: %s", e.Code, e.Message)
+}
+
+// Is reports an ERROR 5 as ErrUnknownTID, and an ERROR 4 or 8, where the
+// server objected to what it was sent, as ErrProtocol.
+func (e *TFTPError) Is(target error) bool {
+	switch target {
+	case ErrUnknownTID:
+		return e.Code == protocol.ERR_UNKNOWN_TID
+	case ErrProtocol:
+		return e.Code == protocol.ERR_ILLEGAL_OPERATION || e.Code == protocol.ERR_OPTION_NEGOTIATION
+	}
+
+	return false
+}
+
+var (
+	// ErrTimeout is returned when the server stops answering and every
+	// retransmission has gone unanswered.
+	ErrTimeout = transfer.ErrMaxRetries
+
+	// ErrCancelled is returned when the context of a transfer is done. The
+	// error also wraps context.Canceled or context.DeadlineExceeded.
+	ErrCancelled = transfer.ErrCancelled
+
+	// ErrUnknownTID is returned when the server does not recognize the
+	// transfer, as when packets reach it from the wrong port.
+	ErrUnknownTID = errors.New("unknown transfer ID")
+
+	// ErrProtocol is returned when the server breaks the protocol, such as
+	// by acknowledging options that were not requested, or reports that
+	// the client did.
+	ErrProtocol = errors.New("protocol violation")
+
+	// ErrLocalIO is returned when reading or writing the local side of a
+	// transfer fails. The error also wraps the underlying one.
+	ErrLocalIO = errors.New("local I/O failure")
+)
+
+// clientError converts an error from a transfer into the one the client returns.
+func clientError(err error) error {
+	var remote *transfer.RemoteError
+	if errors.As(err, &remote) {
+		return &TFTPError{Code: remote.Code, Message: remote.Msg}
+	}
+
+	return err
+}
+
+// localError marks err as a failure on the local side of a transfer.
+func localError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return fmt.Errorf("%w: %w", ErrLocalIO, err)
+}
+
+// localWriter marks errors writing a download as ErrLocalIO.
+type localWriter struct {
+	io.Writer
+}
+
+func (w localWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	return n, localError(err)
+}
+
+// localReader marks errors reading an upload as ErrLocalIO.
+type localReader struct {
+	io.Reader
+}
+
+func (r localReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if err == io.EOF {
+		return n, err
+	}
+
+	return n, localError(err)
+}
@@ -0,0 +1,103 @@
+package client
+
+import (
+	"fmt"
+	"strconv"
+	protocol "tftp/internal/protocol/parse"
+	"tftp/internal/transfer"
+	"time"
+)
+
+// requestOptions returns the RFC 2347 options to send with a request.
+// A non-negative transferSize is sent as tsize.
+func (c *Client) requestOptions(transferSize int64) map[string]string {
+	options := make(map[string]string, len(c.Options)+4)
+	for name, value := range c.Options {
+		options[name] = value
+	}
+
+	if c.BlockSize != 0 {
+		options[protocol.OPTION_BLKSIZE] = strconv.Itoa(c.BlockSize)
+	}
+
+	if c.WindowSize != 0 {
+		options[protocol.OPTION_WINDOWSIZE] = strconv.Itoa(c.WindowSize)
+	}
+
+	if c.Timeout != 0 {
+		options[protocol.OPTION_TIMEOUT] = strconv.Itoa(int(c.Timeout / time.Second))
+	}
+
+	if c.Rollover != "" {
+		options[protocol.OPTION_ROLLOVER] = c.Rollover
+	}
+
+	if transferSize >= 0 {
+		options[protocol.OPTION_TSIZE] = strconv.FormatInt(transferSize, 10)
+	}
+
+	return options
+}
+
+// checkOptionAck verifies that an OACK only acknowledges options we requested,
+// as required by RFC 2347, and applies the acknowledged values to config.
+func checkOptionAck(requested map[string]string, oack protocol.OptionAck, config transfer.Config) (transfer.Config, error) {
+	for name := range oack.Options {
+		if _, exists := requested[name]; !exists {
+			return config, fmt.Errorf("%w: server acknowledged unrequested option %s", ErrProtocol, name)
+		}
+	}
+
+	// RFC 2348 and RFC 7440: the server may lower blksize and windowsize, never raise them.
+	if value, exists := oack.Options[protocol.OPTION_BLKSIZE]; exists {
+		blockSize, err := ackedValue(requested, protocol.OPTION_BLKSIZE, value, protocol.MIN_BLOCK_SIZE)
+		if err != nil {
+			return config, err
+		}
+		config.BlockSize = blockSize
+	}
+
+	if value, exists := oack.Options[protocol.OPTION_WINDOWSIZE]; exists {
+		windowSize, err := ackedValue(requested, protocol.OPTION_WINDOWSIZE, value, protocol.MIN_WINDOW_SIZE)
+		if err != nil {
+			return config, err
+		}
+		config.WindowSize = windowSize
+	}
+
+	// RFC 2349: the timeout is accepted as requested or not at all.
+	// The same goes for rollover, whose values are not interchangeable.
+	for _, name := range []string{protocol.OPTION_TIMEOUT, protocol.OPTION_ROLLOVER} {
+		if value, exists := oack.Options[name]; exists && value != requested[name] {
+			return config, fmt.Errorf("%w: server acknowledged %s %q, requested %q", ErrProtocol, name, value, requested[name])
+		}
+	}
+
+	if oack.Options[protocol.OPTION_ROLLOVER] == "1" {
+		config.Rollover = 1
+	}
+
+	return config, nil
+}
+
+// ackedTransferSize returns the tsize reported in an OACK, if any.
+func ackedTransferSize(oack protocol.OptionAck) (int64, bool) {
+	size, err := strconv.ParseInt(oack.Options[protocol.OPTION_TSIZE], 10, 64)
+	if err != nil || size < 0 {
+		return 0, false
+	}
+
+	return size, true
+}
+
+// ackedValue parses an acknowledged numeric option, which must lie between
+// minimum and the value we requested.
+func ackedValue(requested map[string]string, name, value string, minimum int) (int, error) {
+	requestedValue, _ := strconv.Atoi(requested[name])
+	acked, err := strconv.Atoi(value)
+	if err != nil || acked < minimum || acked > requestedValue {
+		return 0, fmt.Errorf("%w: server acknowledged invalid %s %q", ErrProtocol, name, value)
+	}
+
+	return acked, nil
+}
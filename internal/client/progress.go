@@ -0,0 +1,86 @@
+package client
+
+import (
+	protocol "tftp/internal/protocol/parse"
+	"tftp/internal/transfer"
+	"time"
+)
+
+// Progress describes a transfer under way.
+type Progress struct {
+	Bytes       int64         // Bytes of file data moved so far.
+	Total       int64         // Size of the whole file, or -1 if it is not known.
+	Rate        float64       // Average bytes per second since the request.
+	Retransmits int           // Packets sent again after a timeout.
+	Elapsed     time.Duration // Time since the request.
+	Done        bool          // Set on the final report of a completed transfer.
+}
+
+// progressReporter turns the Stats of a running transfer into calls of
+// Client.OnProgress, no more often than Client.ProgressInterval.
+type progressReporter struct {
+	onProgress func(Progress)
+	interval   time.Duration
+	started    time.Time
+	total      int64
+	// last is when OnProgress was last called.
+	last time.Time
+}
+
+// newProgress returns a reporter for a transfer of total bytes started at
+// started, or nil if no one is listening.
+func (c *Client) newProgress(started time.Time, total int64) *progressReporter {
+	if c.OnProgress == nil {
+		return nil
+	}
+
+	// Progress counts the data on the wire, which netascii makes a
+	// different size from the file.
+	if c.mode() == protocol.MODE_NETASCII {
+		total = -1
+	}
+
+	return &progressReporter{onProgress: c.OnProgress, interval: c.ProgressInterval, started: started, total: total}
+}
+
+// onBlock returns the function for a transfer's OnBlock, nil if p is.
+func (p *progressReporter) onBlock() func(transfer.Stats) {
+	if p == nil {
+		return nil
+	}
+
+	return func(stats transfer.Stats) {
+		now := time.Now()
+		if p.interval > 0 && now.Sub(p.last) < p.interval {
+			return
+		}
+		p.last = now
+		p.report(stats, now, false)
+	}
+}
+
+// done makes the final report of a completed transfer.
+func (p *progressReporter) done(stats transfer.Stats) {
+	if p == nil {
+		return
+	}
+
+	p.report(stats, time.Now(), true)
+}
+
+func (p *progressReporter) report(stats transfer.Stats, now time.Time, done bool) {
+	elapsed := now.Sub(p.started)
+	rate := 0.0
+	if elapsed > 0 {
+		rate = float64(stats.Bytes) / elapsed.Seconds()
+	}
+
+	p.onProgress(Progress{
+		Bytes:       stats.Bytes,
+		Total:       p.total,
+		Rate:        rate,
+		Retransmits: stats.Retransmits,
+		Elapsed:     elapsed,
+		Done:        done,
+	})
+}
@@ -0,0 +1,94 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"tftp/internal/client"
+	tftp "tftp/internal/protocol/parse"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCancelBeforeReply(t *testing.T) {
+	silent := listenLoopback(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	started := time.Now()
+	_, err := client.New(silent.LocalAddr().String()).GetTo(ctx, "file", &bytes.Buffer{})
+	assert.ErrorIs(t, err, client.ErrCancelled)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Less(t, time.Since(started), 2*time.Second, "cancellation must not wait out the retransmit timeout")
+
+	packet, _ := receive(t, silent)
+	assert.IsType(t, tftp.ReadRequest{}, packet)
+	packet, _ = receive(t, silent)
+	assert.Equal(t, tftp.Error{ErrorCode: tftp.ERR_NOT_DEFINED, ErrorMsg: "transfer cancelled"}, packet)
+}
+
+func TestDeadlineDuringTransfer(t *testing.T) {
+	listener := listenLoopback(t)
+	session := listenLoopback(t)
+	go func() {
+		_, from, err := listener.ReadFromUDP(make([]byte, 512))
+		if err == nil {
+			session.WriteToUDP(tftp.Data{BlockNumber: 1, Data: make([]byte, 512)}.ToBinary(), from)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	var buf bytes.Buffer
+	_, err := client.New(listener.LocalAddr().String()).GetTo(ctx, "file", &buf)
+	assert.ErrorIs(t, err, client.ErrCancelled)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, 512, buf.Len())
+
+	// The session's TID is told the transfer is over.
+	packet, _ := receive(t, session)
+	assert.Equal(t, tftp.Ack{BlockNumber: 1}, packet)
+	packet, _ = receive(t, session)
+	assert.Equal(t, tftp.Error{ErrorCode: tftp.ERR_NOT_DEFINED, ErrorMsg: "transfer cancelled"}, packet)
+}
+
+func TestCancelledContextSendsNothing(t *testing.T) {
+	silent := listenLoopback(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := client.New(silent.LocalAddr().String()).PutFrom(ctx, "file", bytes.NewReader(nil), 0)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	silent.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, _, err = silent.ReadFromUDP(make([]byte, 16))
+	assert.Error(t, err, "no request should have been sent")
+}
+
+func listenLoopback(t *testing.T) *net.UDPConn {
+	t.Helper()
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return conn
+}
+
+// receive reads and parses one packet, failing the test after two seconds.
+func receive(t *testing.T, conn *net.UDPConn) (tftp.Packet, *net.UDPAddr) {
+	t.Helper()
+
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, from, err := conn.ReadFromUDP(buf)
+	require.NoError(t, err)
+
+	packet, err := tftp.Parse(buf[:n])
+	require.NoError(t, err)
+	return packet, from
+}
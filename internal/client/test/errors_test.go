@@ -0,0 +1,113 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"tftp/internal/client"
+	tftp "tftp/internal/protocol/parse"
+	"tftp/internal/server"
+	"tftp/internal/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServerErrorsAreTyped(t *testing.T) {
+	cli := client.New(startMemoryServer(t, server.NewMemoryBackend()))
+
+	_, err := cli.GetTo(context.Background(), "missing", &bytes.Buffer{})
+	var tftpErr *client.TFTPError
+	if assert.ErrorAs(t, err, &tftpErr) {
+		assert.Equal(t, tftp.ERR_FILE_NOT_FOUND, tftpErr.Code)
+		assert.Equal(t, "File not found", tftpErr.Message)
+	}
+	assert.NotErrorIs(t, err, client.ErrProtocol)
+	assert.NotErrorIs(t, err, client.ErrLocalIO)
+}
+
+func TestErrorPacketsMatchSentinels(t *testing.T) {
+	for code, sentinel := range map[uint16]error{
+		tftp.ERR_UNKNOWN_TID:        client.ErrUnknownTID,
+		tftp.ERR_ILLEGAL_OPERATION:  client.ErrProtocol,
+		tftp.ERR_OPTION_NEGOTIATION: client.ErrProtocol,
+	} {
+		fake := listenLoopback(t)
+		go func() {
+			_, from, err := fake.ReadFromUDP(make([]byte, 512))
+			if err == nil {
+				fake.WriteToUDP(tftp.NewError(code).ToBinary(), from)
+			}
+		}()
+
+		_, err := client.New(fake.LocalAddr().String()).GetTo(context.Background(), "file", &bytes.Buffer{})
+		assert.ErrorIs(t, err, sentinel, "code %d", code)
+		var tftpErr *client.TFTPError
+		assert.ErrorAs(t, err, &tftpErr)
+	}
+}
+
+func TestUnrequestedOptionIsProtocolError(t *testing.T) {
+	fake := listenLoopback(t)
+	go func() {
+		_, from, err := fake.ReadFromUDP(make([]byte, 512))
+		if err == nil {
+			fake.WriteToUDP(tftp.OptionAck{Options: map[string]string{"blksize": "1024"}}.ToBinary(), from)
+		}
+	}()
+
+	_, err := client.New(fake.LocalAddr().String()).GetTo(context.Background(), "file", &bytes.Buffer{})
+	assert.ErrorIs(t, err, client.ErrProtocol)
+
+	packet, _ := receive(t, fake)
+	if errPacket, ok := packet.(tftp.Error); assert.True(t, ok) {
+		assert.Equal(t, tftp.ERR_OPTION_NEGOTIATION, errPacket.ErrorCode)
+	}
+}
+
+func TestInvalidPortRangeIsRefused(t *testing.T) {
+	silent := listenLoopback(t)
+	cli := client.New(silent.LocalAddr().String())
+	cli.PortRange = utils.PortRange{Min: 50099, Max: 50000}
+
+	_, err := cli.GetTo(context.Background(), "file", &bytes.Buffer{})
+	assert.Error(t, err)
+
+	silent.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, _, err = silent.ReadFromUDP(make([]byte, 16))
+	assert.Error(t, err, "no request should have been sent")
+}
+
+func TestSilentServerTimesOut(t *testing.T) {
+	silent := listenLoopback(t)
+	cli := client.New(silent.LocalAddr().String())
+	cli.Timeout = time.Second
+	cli.MaxRetries = 1
+
+	_, err := cli.GetTo(context.Background(), "file", &bytes.Buffer{})
+	assert.ErrorIs(t, err, client.ErrTimeout)
+	assert.NotErrorIs(t, err, client.ErrCancelled)
+}
+
+type failingWriter struct{}
+
+var errDeviceGone = errors.New("device gone")
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errDeviceGone }
+
+func TestLocalFailuresAreLocalIO(t *testing.T) {
+	backend := server.NewMemoryBackend()
+	backend.Put("file", []byte("contents"))
+	cli := client.New(startMemoryServer(t, backend))
+
+	_, err := cli.GetTo(context.Background(), "file", failingWriter{})
+	assert.ErrorIs(t, err, client.ErrLocalIO)
+	assert.ErrorIs(t, err, errDeviceGone)
+
+	_, err = cli.Put(context.Background(), "file", "/nonexistent/file")
+	assert.ErrorIs(t, err, client.ErrLocalIO)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
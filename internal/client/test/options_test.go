@@ -0,0 +1,240 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"tftp/internal/client"
+	tftp "tftp/internal/protocol/parse"
+	"tftp/internal/server"
+	"tftp/internal/transfer"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlockSizeNegotiatedWithServer(t *testing.T) {
+	backend := server.NewMemoryBackend()
+	contents := strings.Repeat("z", 2500)
+	backend.Put("file", []byte(contents))
+	cli := client.New(startMemoryServer(t, backend))
+	cli.BlockSize = 1024
+
+	var oack tftp.OptionAck
+	var blocks []int
+	cli.Trace = func(sent bool, packet []byte) {
+		switch parsed, _ := tftp.Parse(packet); parsed := parsed.(type) {
+		case tftp.OptionAck:
+			oack = parsed
+		case tftp.Data:
+			blocks = append(blocks, len(parsed.Data))
+		}
+	}
+
+	var buf bytes.Buffer
+	result, err := cli.GetTo(context.Background(), "file", &buf)
+	require.NoError(t, err)
+	assert.Equal(t, contents, buf.String())
+	assert.Equal(t, "1024", oack.Options[tftp.OPTION_BLKSIZE])
+	assert.Equal(t, []int{1024, 1024, 452}, blocks)
+	assert.Equal(t, uint64(3), result.Blocks)
+}
+
+func TestLoweredBlockSizeIsUsed(t *testing.T) {
+	fake := listenLoopback(t)
+	cli := client.New(fake.LocalAddr().String())
+	cli.BlockSize = 1024
+
+	type outcome struct {
+		data string
+		err  error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		var buf bytes.Buffer
+		_, err := cli.GetTo(context.Background(), "file", &buf)
+		done <- outcome{buf.String(), err}
+	}()
+
+	request, from := receive(t, fake)
+	require.Equal(t, "1024", request.(tftp.ReadRequest).Options[tftp.OPTION_BLKSIZE])
+
+	// The server may lower blksize; a full block is then 512 bytes.
+	_, err := fake.WriteToUDP(tftp.OptionAck{Options: map[string]string{tftp.OPTION_BLKSIZE: "512"}}.ToBinary(), from)
+	require.NoError(t, err)
+	ack, _ := receive(t, fake)
+	require.Equal(t, tftp.Ack{BlockNumber: 0}, ack)
+
+	full := strings.Repeat("a", 512)
+	for block, data := range []string{full, "tail"} {
+		_, err = fake.WriteToUDP(tftp.Data{BlockNumber: uint16(block + 1), Data: []byte(data)}.ToBinary(), from)
+		require.NoError(t, err)
+		ack, _ = receive(t, fake)
+		require.Equal(t, tftp.Ack{BlockNumber: uint16(block + 1)}, ack)
+	}
+
+	select {
+	case result := <-done:
+		require.NoError(t, result.err)
+		assert.Equal(t, full+"tail", result.data)
+	case <-time.After(2 * time.Second):
+		t.Fatal("transfer did not finish")
+	}
+}
+
+func TestRaisedBlockSizeIsProtocolError(t *testing.T) {
+	fake := listenLoopback(t)
+	go func() {
+		_, from, err := fake.ReadFromUDP(make([]byte, 512))
+		if err == nil {
+			fake.WriteToUDP(tftp.OptionAck{Options: map[string]string{tftp.OPTION_BLKSIZE: "2048"}}.ToBinary(), from)
+		}
+	}()
+
+	cli := client.New(fake.LocalAddr().String())
+	cli.BlockSize = 1024
+	_, err := cli.GetTo(context.Background(), "file", &bytes.Buffer{})
+	assert.ErrorIs(t, err, client.ErrProtocol)
+
+	packet, _ := receive(t, fake)
+	if errPacket, ok := packet.(tftp.Error); assert.True(t, ok) {
+		assert.Equal(t, tftp.ERR_OPTION_NEGOTIATION, errPacket.ErrorCode)
+	}
+}
+
+func TestOutOfRangeBlockSizeIsNotSent(t *testing.T) {
+	silent := listenLoopback(t)
+
+	for _, blockSize := range []int{7, tftp.MAX_BLOCK_SIZE + 1} {
+		cli := client.New(silent.LocalAddr().String())
+		cli.BlockSize = blockSize
+		_, err := cli.GetTo(context.Background(), "file", &bytes.Buffer{})
+		assert.Error(t, err, "block size %d", blockSize)
+	}
+
+	silent.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, _, err := silent.ReadFromUDP(make([]byte, 16))
+	assert.Error(t, err, "no request should have been sent")
+}
+
+func TestOversizedWindowIsNotSent(t *testing.T) {
+	silent := listenLoopback(t)
+
+	for _, windowSize := range []int{tftp.WINDOW_SIZE_LIMIT + 1, tftp.MAX_WINDOW_SIZE} {
+		cli := client.New(silent.LocalAddr().String())
+		cli.WindowSize = windowSize
+		_, err := cli.GetTo(context.Background(), "file", &bytes.Buffer{})
+		assert.ErrorContains(t, err, "window size", "window size %d", windowSize)
+	}
+
+	silent.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, _, err := silent.ReadFromUDP(make([]byte, 16))
+	assert.Error(t, err, "no request should have been sent")
+}
+
+func TestPutDeclaresTransferSize(t *testing.T) {
+	backend := server.NewMemoryBackend()
+	cli := client.New(startMemoryServer(t, backend))
+	local := filepath.Join(t.TempDir(), "config")
+	require.NoError(t, os.WriteFile(local, []byte("line one\nline two\n"), 0o644))
+
+	for mode, tsize := range map[string]string{
+		tftp.MODE_OCTET: "18",
+		// Each LF goes out as CR LF, so the file's size would be wrong.
+		tftp.MODE_NETASCII: "",
+	} {
+		cli.Mode = mode
+		var wrq tftp.WriteRequest
+		cli.Trace = func(sent bool, packet []byte) {
+			if parsed, _ := tftp.Parse(packet); sent {
+				if request, ok := parsed.(tftp.WriteRequest); ok {
+					wrq = request
+				}
+			}
+		}
+
+		_, err := cli.Put(context.Background(), "config", local)
+		require.NoError(t, err, mode)
+		assert.Equal(t, tsize, wrq.Options[tftp.OPTION_TSIZE], mode)
+		stored, _ := backend.Get("config")
+		assert.Equal(t, "line one\nline two\n", string(stored), mode)
+		backend.Put("config", nil)
+	}
+}
+
+func TestTimeoutMustBeNegotiable(t *testing.T) {
+	silent := listenLoopback(t)
+
+	for _, timeout := range []time.Duration{500 * time.Millisecond, 256 * time.Second, 1500 * time.Millisecond} {
+		cli := client.New(silent.LocalAddr().String())
+		cli.Timeout = timeout
+		_, err := cli.GetTo(context.Background(), "file", &bytes.Buffer{})
+		assert.Error(t, err, "timeout %v", timeout)
+	}
+
+	silent.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, _, err := silent.ReadFromUDP(make([]byte, 16))
+	assert.Error(t, err, "no request should have been sent")
+}
+
+func TestChangedTimeoutIsProtocolError(t *testing.T) {
+	fake := listenLoopback(t)
+	go func() {
+		_, from, err := fake.ReadFromUDP(make([]byte, 512))
+		if err == nil {
+			fake.WriteToUDP(tftp.OptionAck{Options: map[string]string{tftp.OPTION_TIMEOUT: "5"}}.ToBinary(), from)
+		}
+	}()
+
+	cli := client.New(fake.LocalAddr().String())
+	cli.Timeout = 2 * time.Second
+	_, err := cli.GetTo(context.Background(), "file", &bytes.Buffer{})
+	assert.ErrorIs(t, err, client.ErrProtocol)
+}
+
+func TestUnacknowledgedRolloverWrapsToZero(t *testing.T) {
+	// A server that ignores rollover, as RFC 1350 servers do, acknowledges
+	// the other options and wraps block 65535 to 0.
+	fake := listenLoopback(t)
+	data := make([]byte, 70000*8)
+	rand.Read(data)
+	go func() {
+		buf := make([]byte, 512)
+		n, from, err := fake.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		request, _ := tftp.Parse(buf[:n])
+		if request.(tftp.ReadRequest).Options[tftp.OPTION_ROLLOVER] != "1" {
+			return
+		}
+
+		config := transfer.DefaultConfig()
+		config.BlockSize = 8
+		config.WindowSize = 64
+		sender := transfer.Sender{
+			Config:  config,
+			Request: tftp.OptionAck{Options: map[string]string{tftp.OPTION_BLKSIZE: "8", tftp.OPTION_WINDOWSIZE: "64"}}.ToBinary(),
+		}
+		sender.Send(context.Background(), transfer.NewPeerConn(fake, from), bytes.NewReader(data))
+	}()
+
+	cli := client.New(fake.LocalAddr().String())
+	cli.BlockSize = 8
+	cli.WindowSize = 64
+	cli.Rollover = "1"
+	cli.Timeout = time.Second
+	cli.MaxRetries = 2
+
+	var buf bytes.Buffer
+	result, err := cli.GetTo(context.Background(), "file", &buf)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(70001), result.Blocks)
+	assert.True(t, bytes.Equal(data, buf.Bytes()))
+}
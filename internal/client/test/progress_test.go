@@ -0,0 +1,58 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"tftp/internal/client"
+	"tftp/internal/server"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProgressReportsEachBlock(t *testing.T) {
+	backend := server.NewMemoryBackend()
+	backend.Put("file", bytes.Repeat([]byte("x"), 100))
+	cli := client.New(startMemoryServer(t, backend))
+	cli.BlockSize = 8
+
+	var reports []client.Progress
+	cli.OnProgress = func(p client.Progress) { reports = append(reports, p) }
+	_, err := cli.GetTo(context.Background(), "file", &bytes.Buffer{})
+	require.NoError(t, err)
+
+	// 13 blocks, then the final report.
+	require.Len(t, reports, 14)
+	for i, report := range reports[:13] {
+		assert.Equal(t, min(int64(8*(i+1)), 100), report.Bytes)
+		assert.Equal(t, int64(100), report.Total, "the size comes from tsize")
+		assert.False(t, report.Done)
+	}
+	final := reports[13]
+	assert.True(t, final.Done)
+	assert.Equal(t, int64(100), final.Bytes)
+	assert.Positive(t, final.Rate)
+	assert.Positive(t, final.Elapsed)
+}
+
+func TestProgressInterval(t *testing.T) {
+	backend := server.NewMemoryBackend()
+	cli := client.New(startMemoryServer(t, backend))
+	cli.BlockSize = 8
+	cli.ProgressInterval = time.Hour
+
+	var reports []client.Progress
+	cli.OnProgress = func(p client.Progress) { reports = append(reports, p) }
+	contents := strings.Repeat("y", 100)
+	_, err := cli.PutFrom(context.Background(), "file", strings.NewReader(contents), int64(len(contents)))
+	require.NoError(t, err)
+
+	// Only the first block and the final report fall outside the interval.
+	require.Len(t, reports, 2)
+	assert.Equal(t, int64(8), reports[0].Bytes)
+	assert.Equal(t, client.Progress{Bytes: 100, Total: 100, Rate: reports[1].Rate, Elapsed: reports[1].Elapsed, Done: true}, reports[1])
+}
@@ -0,0 +1,68 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"tftp/internal/client"
+	"tftp/internal/server"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startMemoryServer runs a server for backend on a free localhost port,
+// shutting it down when the test ends.
+func startMemoryServer(t *testing.T, backend *server.MemoryBackend) string {
+	t.Helper()
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+
+	srv := server.New(nil, backend)
+	go srv.Serve(context.Background(), conn)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		srv.Shutdown(ctx)
+	})
+
+	return conn.LocalAddr().String()
+}
+
+func TestGetToWriter(t *testing.T) {
+	backend := server.NewMemoryBackend()
+	backend.Put("config", []byte("hostname router1\n"))
+	cli := client.New(startMemoryServer(t, backend))
+	cli.BlockSize = 8
+
+	var buf bytes.Buffer
+	result, err := cli.GetTo(context.Background(), "config", &buf)
+	require.NoError(t, err)
+	assert.Equal(t, "hostname router1\n", buf.String())
+	assert.Equal(t, int64(17), result.Bytes)
+	assert.Equal(t, uint64(3), result.Blocks)
+	assert.Zero(t, result.Retransmits)
+	assert.Positive(t, result.Duration)
+}
+
+func TestPutFromReader(t *testing.T) {
+	backend := server.NewMemoryBackend()
+	cli := client.New(startMemoryServer(t, backend))
+
+	for _, sizeHint := range []int64{-1, 1000} {
+		contents := strings.Repeat("generated ", 100)
+		result, err := cli.PutFrom(context.Background(), "generated", strings.NewReader(contents), sizeHint)
+		require.NoError(t, err)
+		assert.Equal(t, int64(len(contents)), result.Bytes)
+		assert.Equal(t, uint64(2), result.Blocks)
+
+		stored, _ := backend.Get("generated")
+		assert.Equal(t, contents, string(stored))
+		backend.Put("generated", nil)
+	}
+}
@@ -0,0 +1,35 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"tftp/internal/client"
+	tftp "tftp/internal/protocol/parse"
+	"tftp/internal/server"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTraceSeesEveryPacket(t *testing.T) {
+	backend := server.NewMemoryBackend()
+	backend.Put("file", []byte("hello"))
+	cli := client.New(startMemoryServer(t, backend))
+
+	var trace []string
+	cli.Trace = func(sent bool, packet []byte) {
+		parsed, err := tftp.Parse(packet)
+		require.NoError(t, err)
+		direction := "received"
+		if sent {
+			direction = "sent"
+		}
+		trace = append(trace, direction+" "+parsed.OpCode().String())
+	}
+	_, err := cli.GetTo(context.Background(), "file", &bytes.Buffer{})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"sent RRQ", "received DATA", "sent ACK"}, trace)
+}
@@ -0,0 +1,45 @@
+package client
+
+import (
+	"net"
+	"tftp/internal/transfer"
+	"time"
+)
+
+// transferConn returns the Conn a transfer runs over, reporting every
+// packet to Trace if it is set.
+func (c *Client) transferConn(conn *net.UDPConn, raddr *net.UDPAddr) transfer.Conn {
+	udpConn := transfer.NewUDPConn(conn, raddr)
+	if c.Trace == nil {
+		return udpConn
+	}
+
+	return &tracingConn{Conn: udpConn, trace: c.Trace}
+}
+
+// tracingConn passes every packet sent and received to trace.
+type tracingConn struct {
+	transfer.Conn
+	trace func(sent bool, packet []byte)
+}
+
+func (c *tracingConn) Send(packet []byte) error {
+	c.trace(true, packet)
+	return c.Conn.Send(packet)
+}
+
+func (c *tracingConn) Receive(buf []byte, timeout time.Duration) (int, error) {
+	n, err := c.Conn.Receive(buf, timeout)
+	if err == nil {
+		c.trace(false, buf[:n])
+	}
+
+	return n, err
+}
+
+// Interrupt keeps cancellation prompt by passing it on to the wrapped Conn.
+func (c *tracingConn) Interrupt() {
+	if i, ok := c.Conn.(interface{ Interrupt() }); ok {
+		i.Interrupt()
+	}
+}
@@ -0,0 +1,260 @@
+// Package lineedit reads lines typed at a terminal, with cursor movement,
+// emacs-style editing keys and a history recalled with the arrow keys.
+package lineedit
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// HISTORY_SIZE is how many lines an Editor remembers.
+const HISTORY_SIZE = 500
+
+// ErrInterrupted is returned by ReadLine when Ctrl-C abandons the line.
+var ErrInterrupted = errors.New("interrupted")
+
+// Control keys understood by ReadLine.
+const (
+	keyCtrlA     = 1
+	keyCtrlB     = 2
+	keyCtrlC     = 3
+	keyCtrlD     = 4
+	keyCtrlE     = 5
+	keyCtrlF     = 6
+	keyBackspace = 8
+	keyCtrlK     = 11
+	keyCtrlN     = 14
+	keyCtrlP     = 16
+	keyCtrlU     = 21
+	keyCtrlW     = 23
+	keyEscape    = 27
+	keyDelete    = 127
+)
+
+// Editor reads lines from in, echoing and redrawing them on out.
+type Editor struct {
+	in  *bufio.Reader
+	out io.Writer
+	// fd is the terminal put into raw mode while a line is read, or -1.
+	fd      int
+	history []string
+}
+
+// New returns an Editor reading keys from in, which must already deliver
+// them one at a time, unechoed, as a terminal in raw mode does.
+func New(in io.Reader, out io.Writer) *Editor {
+	return &Editor{in: bufio.NewReader(in), out: out, fd: -1}
+}
+
+// NewTerminal returns an Editor for the terminal f, which is put into raw
+// mode only while ReadLine runs. It fails if f is not a terminal that can
+// be put into raw mode.
+func NewTerminal(f *os.File, out io.Writer) (*Editor, error) {
+	state, err := term.MakeRaw(int(f.Fd()))
+	if err != nil {
+		return nil, fmt.Errorf("cannot edit lines on %s: %w", f.Name(), err)
+	}
+	term.Restore(int(f.Fd()), state)
+
+	e := New(f, out)
+	e.fd = int(f.Fd())
+	return e, nil
+}
+
+// History returns the lines read so far, oldest first.
+func (e *Editor) History() []string {
+	return e.history
+}
+
+// ReadLine shows prompt and returns the line typed after it. It returns
+// io.EOF for Ctrl-D on an empty line or at the end of the input, and
+// ErrInterrupted for Ctrl-C.
+func (e *Editor) ReadLine(prompt string) (string, error) {
+	if e.fd >= 0 {
+		// Raw mode also turns off output processing, which is why lines
+		// are ended with "\r\n" below.
+		state, err := term.MakeRaw(e.fd)
+		if err != nil {
+			return "", err
+		}
+		defer term.Restore(e.fd, state)
+	}
+
+	l := line{prompt: prompt, out: e.out, recall: len(e.history)}
+	fmt.Fprint(e.out, prompt)
+	for {
+		key, _, err := e.in.ReadRune()
+		if err != nil {
+			if err == io.EOF && len(l.text) > 0 {
+				break
+			}
+			return "", err
+		}
+
+		switch key {
+		case '\r', '\n':
+			fmt.Fprint(e.out, "\r\n")
+			return e.remember(string(l.text)), nil
+		case keyCtrlC:
+			fmt.Fprint(e.out, "^C\r\n")
+			return "", ErrInterrupted
+		case keyCtrlD:
+			if len(l.text) == 0 {
+				fmt.Fprint(e.out, "\r\n")
+				return "", io.EOF
+			}
+			l.deleteAt(l.pos)
+		case keyBackspace, keyDelete:
+			if l.pos > 0 {
+				l.pos--
+				l.deleteAt(l.pos)
+			}
+		case keyCtrlA:
+			l.pos = 0
+		case keyCtrlE:
+			l.pos = len(l.text)
+		case keyCtrlB:
+			l.pos = max(l.pos-1, 0)
+		case keyCtrlF:
+			l.pos = min(l.pos+1, len(l.text))
+		case keyCtrlK:
+			l.text = l.text[:l.pos]
+		case keyCtrlU:
+			l.text = l.text[l.pos:]
+			l.pos = 0
+		case keyCtrlW:
+			start := l.pos
+			for start > 0 && l.text[start-1] == ' ' {
+				start--
+			}
+			for start > 0 && l.text[start-1] != ' ' {
+				start--
+			}
+			l.text = append(l.text[:start], l.text[l.pos:]...)
+			l.pos = start
+		case keyCtrlP:
+			e.recall(&l, -1)
+		case keyCtrlN:
+			e.recall(&l, 1)
+		case keyEscape:
+			e.escape(&l)
+		default:
+			if key >= ' ' {
+				l.insert(key)
+			}
+		}
+		l.redraw()
+	}
+
+	fmt.Fprint(e.out, "\r\n")
+	return e.remember(string(l.text)), nil
+}
+
+// escape handles the rest of an escape sequence sent by a cursor key.
+func (e *Editor) escape(l *line) {
+	next, _, err := e.in.ReadRune()
+	if err != nil || (next != '[' && next != 'O') {
+		return
+	}
+
+	code, _, err := e.in.ReadRune()
+	if err != nil {
+		return
+	}
+	// Home, End and Delete may be sent as "ESC [ n ~".
+	if code >= '0' && code <= '9' {
+		if tilde, _, err := e.in.ReadRune(); err != nil || tilde != '~' {
+			return
+		}
+	}
+
+	switch code {
+	case 'A':
+		e.recall(l, -1)
+	case 'B':
+		e.recall(l, 1)
+	case 'C':
+		l.pos = min(l.pos+1, len(l.text))
+	case 'D':
+		l.pos = max(l.pos-1, 0)
+	case 'H', '1', '7':
+		l.pos = 0
+	case 'F', '4', '8':
+		l.pos = len(l.text)
+	case '3':
+		if l.pos < len(l.text) {
+			l.deleteAt(l.pos)
+		}
+	}
+}
+
+// recall replaces the line with the history entry step entries away from
+// the one shown. Stepping past the newest entry returns to the line being
+// typed before history was recalled.
+func (e *Editor) recall(l *line, step int) {
+	next := l.recall + step
+	if next < 0 || next > len(e.history) {
+		return
+	}
+
+	if l.recall == len(e.history) {
+		l.draft = l.text
+	}
+	l.recall = next
+	if next == len(e.history) {
+		l.text = l.draft
+	} else {
+		l.text = []rune(e.history[next])
+	}
+	l.pos = len(l.text)
+}
+
+// remember adds a line to the history, unless it is blank or repeats the last one.
+func (e *Editor) remember(text string) string {
+	if strings.TrimSpace(text) == "" || (len(e.history) > 0 && e.history[len(e.history)-1] == text) {
+		return text
+	}
+
+	e.history = append(e.history, text)
+	if len(e.history) > HISTORY_SIZE {
+		e.history = e.history[len(e.history)-HISTORY_SIZE:]
+	}
+	return text
+}
+
+// line is a line being edited.
+type line struct {
+	prompt string
+	out    io.Writer
+	text   []rune
+	pos    int
+	// recall is the history entry shown, len(history) for the line being
+	// typed, which is kept in draft while history is shown.
+	recall int
+	draft  []rune
+}
+
+func (l *line) insert(r rune) {
+	l.text = append(l.text[:l.pos], append([]rune{r}, l.text[l.pos:]...)...)
+	l.pos++
+}
+
+func (l *line) deleteAt(i int) {
+	if i < len(l.text) {
+		l.text = append(l.text[:i], l.text[i+1:]...)
+	}
+}
+
+// redraw rewrites the prompt and text, then puts the cursor back at pos.
+func (l *line) redraw() {
+	fmt.Fprintf(l.out, "\r%s%s\033[K", l.prompt, string(l.text))
+	if back := len(l.text) - l.pos; back > 0 {
+		fmt.Fprintf(l.out, "\033[%dD", back)
+	}
+}
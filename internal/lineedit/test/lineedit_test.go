@@ -0,0 +1,81 @@
+package test
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"tftp/internal/lineedit"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readLines(t *testing.T, keys string) ([]string, error) {
+	t.Helper()
+
+	editor := lineedit.New(strings.NewReader(keys), io.Discard)
+	var lines []string
+	for {
+		line, err := editor.ReadLine("tftp> ")
+		if err != nil {
+			return lines, err
+		}
+		lines = append(lines, line)
+	}
+}
+
+func TestEditing(t *testing.T) {
+	for _, test := range []struct {
+		name, keys, want string
+	}{
+		{"plain", "get file\r", "get file"},
+		{"backspace", "get fiel\x7f\x7fle\r", "get file"},
+		{"insert after moving left", "get fle\x1b[D\x1b[Di\r", "get file"},
+		{"home and end", "et file\x01g\x05s\r", "get files"},
+		{"ctrl-u", "junk\x15get file\r", "get file"},
+		{"ctrl-k", "get file junk\x02\x02\x02\x02\x02\x0b\r", "get file"},
+		{"ctrl-w", "get junk\x17file\r", "get file"},
+		{"delete key", "get xfile\x01\x1b[C\x1b[C\x1b[C\x1b[C\x1b[3~\r", "get file"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			lines, err := readLines(t, test.keys)
+			assert.ErrorIs(t, err, io.EOF)
+			assert.Equal(t, []string{test.want}, lines)
+		})
+	}
+}
+
+func TestHistory(t *testing.T) {
+	// Up twice recalls the first line, down returns to the second.
+	lines, err := readLines(t, "connect host\rbinary\r\x1b[A\x1b[A\x1b[B\r\x10\x10\x0e\x0e\r")
+	assert.ErrorIs(t, err, io.EOF)
+	assert.Equal(t, []string{"connect host", "binary", "binary", ""}, lines)
+
+	editor := lineedit.New(strings.NewReader("a\ra\r\rb\r"), io.Discard)
+	for range 4 {
+		_, err := editor.ReadLine("> ")
+		require.NoError(t, err)
+	}
+	assert.Equal(t, []string{"a", "b"}, editor.History(), "blank and repeated lines are not remembered")
+}
+
+func TestDraftSurvivesHistory(t *testing.T) {
+	lines, err := readLines(t, "status\rget fi\x1b[A\x1b[Ble\r")
+	assert.ErrorIs(t, err, io.EOF)
+	assert.Equal(t, []string{"status", "get file"}, lines)
+}
+
+func TestControlKeys(t *testing.T) {
+	lines, err := readLines(t, "half\x03")
+	assert.ErrorIs(t, err, lineedit.ErrInterrupted)
+	assert.Empty(t, lines)
+
+	lines, err = readLines(t, "quit\r\x04")
+	assert.ErrorIs(t, err, io.EOF)
+	assert.Equal(t, []string{"quit"}, lines)
+
+	lines, err = readLines(t, "no newline")
+	assert.ErrorIs(t, err, io.EOF)
+	assert.Equal(t, []string{"no newline"}, lines, "a last line without a newline is still read")
+}
@@ -0,0 +1,114 @@
+// Package netascii translates between local text and the netascii form
+// TFTP uses on the wire (RFC 1350, RFC 764).
+//
+// On the wire every line ends in CR LF and a bare CR is sent as CR NUL.
+// Local text is assumed to end lines with a bare LF.
+package netascii
+
+import "io"
+
+const (
+	cr  = '\r'
+	lf  = '\n'
+	nul = 0x00
+)
+
+// Reader encodes local text read from an underlying reader into netascii.
+type Reader struct {
+	r       io.Reader
+	scratch []byte
+	// pending holds encoded bytes not yet returned by Read.
+	pending []byte
+	err     error
+}
+
+// NewReader returns a Reader encoding the text read from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: r, scratch: make([]byte, 4096)}
+}
+
+func (n *Reader) Read(p []byte) (int, error) {
+	for len(n.pending) == 0 {
+		if n.err != nil {
+			return 0, n.err
+		}
+
+		// Encoding at most doubles the size, so read half of p at a time.
+		chunk := n.scratch[:min(len(n.scratch), max(len(p)/2, 1))]
+		read, err := n.r.Read(chunk)
+		n.err = err
+		for _, b := range chunk[:read] {
+			switch b {
+			case lf:
+				n.pending = append(n.pending, cr, lf)
+			case cr:
+				n.pending = append(n.pending, cr, nul)
+			default:
+				n.pending = append(n.pending, b)
+			}
+		}
+	}
+
+	copied := copy(p, n.pending)
+	n.pending = n.pending[copied:]
+	return copied, nil
+}
+
+// Writer decodes netascii written to it into local text on an underlying writer.
+// A CR at the end of one Write is held until the next shows what follows it,
+// so pairs split across blocks decode correctly. Close flushes a trailing CR.
+type Writer struct {
+	w io.Writer
+	// cr is set when the last byte written was a CR not yet decoded.
+	cr  bool
+	out []byte
+}
+
+// NewWriter returns a Writer decoding netascii into w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+func (n *Writer) Write(p []byte) (int, error) {
+	n.out = n.out[:0]
+	for _, b := range p {
+		if n.cr {
+			n.cr = false
+			switch b {
+			case lf:
+				n.out = append(n.out, lf)
+				continue
+			case nul:
+				n.out = append(n.out, cr)
+				continue
+			default:
+				// Not valid netascii; keep the CR rather than lose data.
+				n.out = append(n.out, cr)
+			}
+		}
+
+		if b == cr {
+			n.cr = true
+			continue
+		}
+		n.out = append(n.out, b)
+	}
+
+	if _, err := n.w.Write(n.out); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// Close writes a CR left dangling at the end of the data. It does not close
+// the underlying writer.
+func (n *Writer) Close() error {
+	if !n.cr {
+		return nil
+	}
+
+	n.cr = false
+	_, err := n.w.Write([]byte{cr})
+	return err
+}
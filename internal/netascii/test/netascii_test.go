@@ -0,0 +1,83 @@
+package test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"testing/iotest"
+	"tftp/internal/netascii"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testCases = []struct {
+	local string
+	wire  string
+}{
+	{"", ""},
+	{"plain", "plain"},
+	{"line\n", "line\r\n"},
+	{"a\nb\n\nc", "a\r\nb\r\n\r\nc"},
+	{"carriage\rreturn", "carriage\r\x00return"},
+	{"\r\n", "\r\x00\r\n"},
+	{"\r", "\r\x00"},
+	{"\n\r\r\n", "\r\n\r\x00\r\x00\r\n"},
+}
+
+func TestEncode(t *testing.T) {
+	for _, tc := range testCases {
+		// One byte at a time exercises every split point.
+		encoded, err := io.ReadAll(netascii.NewReader(iotest.OneByteReader(bytes.NewReader([]byte(tc.local)))))
+		assert.NoError(t, err)
+		assert.Equal(t, tc.wire, string(encoded))
+
+		encoded, err = io.ReadAll(netascii.NewReader(bytes.NewReader([]byte(tc.local))))
+		assert.NoError(t, err)
+		assert.Equal(t, tc.wire, string(encoded))
+	}
+}
+
+func TestDecode(t *testing.T) {
+	for _, tc := range testCases {
+		var decoded bytes.Buffer
+		w := netascii.NewWriter(&decoded)
+		_, err := w.Write([]byte(tc.wire))
+		assert.NoError(t, err)
+		assert.NoError(t, w.Close())
+		assert.Equal(t, tc.local, decoded.String())
+	}
+}
+
+func TestDecodeSplitAcrossBlocks(t *testing.T) {
+	for _, tc := range testCases {
+		for split := 0; split <= len(tc.wire); split++ {
+			var decoded bytes.Buffer
+			w := netascii.NewWriter(&decoded)
+			w.Write([]byte(tc.wire[:split]))
+			w.Write([]byte(tc.wire[split:]))
+			w.Close()
+			assert.Equal(t, tc.local, decoded.String(), "split at %d of %q", split, tc.wire)
+		}
+	}
+}
+
+func TestDecodeTrailingCR(t *testing.T) {
+	var decoded bytes.Buffer
+	w := netascii.NewWriter(&decoded)
+	w.Write([]byte("end\r"))
+	assert.Equal(t, "end", decoded.String())
+
+	w.Close()
+	assert.Equal(t, "end\r", decoded.String())
+}
+
+func TestRoundTrip(t *testing.T) {
+	local := bytes.Repeat([]byte("line one\r\nline two\n\r\x00\xff"), 1000)
+
+	var decoded bytes.Buffer
+	w := netascii.NewWriter(&decoded)
+	_, err := io.CopyBuffer(w, netascii.NewReader(bytes.NewReader(local)), make([]byte, 512))
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+	assert.Equal(t, local, decoded.Bytes())
+}
@@ -0,0 +1,61 @@
+package tftp
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// Error codes (RFC 1350, RFC 2347).
+const (
+	ERR_NOT_DEFINED        uint16 = 0 // Not defined, see error message (if any).
+	ERR_FILE_NOT_FOUND     uint16 = 1 // File not found.
+	ERR_ACCESS_VIOLATION   uint16 = 2 // Access violation.
+	ERR_DISK_FULL          uint16 = 3 // Disk full or allocation exceeded.
+	ERR_ILLEGAL_OPERATION  uint16 = 4 // Illegal TFTP operation.
+	ERR_UNKNOWN_TID        uint16 = 5 // Unknown transfer ID.
+	ERR_FILE_EXISTS        uint16 = 6 // File already exists.
+	ERR_NO_SUCH_USER       uint16 = 7 // No such user.
+	ERR_OPTION_NEGOTIATION uint16 = 8 // Terminate transfer due to option negotiation.
+)
+
+// ERROR_MESSAGES holds the default message for each error code.
+var ERROR_MESSAGES = map[uint16]string{
+	ERR_NOT_DEFINED:        "Not defined",
+	ERR_FILE_NOT_FOUND:     "File not found",
+	ERR_ACCESS_VIOLATION:   "Access violation",
+	ERR_DISK_FULL:          "Disk full or allocation exceeded",
+	ERR_ILLEGAL_OPERATION:  "Illegal TFTP operation",
+	ERR_UNKNOWN_TID:        "Unknown transfer ID",
+	ERR_FILE_EXISTS:        "File already exists",
+	ERR_NO_SUCH_USER:       "No such user",
+	ERR_OPTION_NEGOTIATION: "Option negotiation failed",
+}
+
+// NewError returns an ERROR packet with the default message for code.
+func NewError(code uint16) Error {
+	return Error{ErrorCode: code, ErrorMsg: ERROR_MESSAGES[code]}
+}
+
+// ErrorFromOS maps a local failure to the ERROR packet reporting it.
+//
+// An Error anywhere in err's chain is returned as is. Missing files,
+// permission failures, full disks and existing files map to codes 1, 2, 3
+// and 6; anything else is code 0 carrying err's text.
+func ErrorFromOS(err error) Error {
+	var packet Error
+	switch {
+	case errors.As(err, &packet):
+		return packet
+	case errors.Is(err, os.ErrNotExist):
+		return NewError(ERR_FILE_NOT_FOUND)
+	case errors.Is(err, os.ErrPermission):
+		return NewError(ERR_ACCESS_VIOLATION)
+	case errors.Is(err, syscall.ENOSPC), errors.Is(err, syscall.EDQUOT), errors.Is(err, syscall.EFBIG):
+		return NewError(ERR_DISK_FULL)
+	case errors.Is(err, os.ErrExist):
+		return NewError(ERR_FILE_EXISTS)
+	default:
+		return Error{ErrorCode: ERR_NOT_DEFINED, ErrorMsg: err.Error()}
+	}
+}
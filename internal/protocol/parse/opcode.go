@@ -2,6 +2,8 @@ package tftp
 
 import (
 	"encoding/binary"
+	"sort"
+	"strconv"
 )
 
 type OpCode uint16
@@ -12,11 +14,50 @@ const (
 	DATA          OpCode = 3 // Data
 	ACK           OpCode = 4 // Acknowledgment
 	ERROR         OpCode = 5 // Error
+	OACK          OpCode = 6 // Option acknowledgment (RFC 2347)
 	MODE_NETASCII        = "netascii"
 	MODE_OCTET           = "octet"
 	MODE_MAIL            = "mail"
 )
 
+// Option names and limits for the negotiated transfer parameters.
+const (
+	OPTION_BLKSIZE      = "blksize"    // RFC 2348
+	OPTION_WINDOWSIZE   = "windowsize" // RFC 7440
+	OPTION_TSIZE        = "tsize"      // RFC 2349
+	OPTION_TIMEOUT      = "timeout"    // RFC 2349, in seconds.
+	OPTION_ROLLOVER     = "rollover"   // Block number following 65535, "0" or "1".
+	DEFAULT_BLOCK_SIZE  = 512          // RFC 1350 block size when blksize is not negotiated.
+	MIN_BLOCK_SIZE      = 8
+	MAX_BLOCK_SIZE      = 65464
+	DEFAULT_WINDOW_SIZE = 1 // RFC 1350 lock-step when windowsize is not negotiated.
+	MIN_WINDOW_SIZE     = 1
+	MAX_WINDOW_SIZE     = 65535
+	WINDOW_SIZE_LIMIT   = 64 // Largest windowsize used, as a sender buffers a whole window.
+	MIN_TIMEOUT         = 1
+	MAX_TIMEOUT         = 255
+)
+
+// String returns the opcode's name, such as "RRQ".
+func (o OpCode) String() string {
+	switch o {
+	case RRQ:
+		return "RRQ"
+	case WRQ:
+		return "WRQ"
+	case DATA:
+		return "DATA"
+	case ACK:
+		return "ACK"
+	case ERROR:
+		return "ERROR"
+	case OACK:
+		return "OACK"
+	}
+
+	return "OpCode(" + strconv.Itoa(int(o)) + ")"
+}
+
 /*
 The  TFTP header consists of a **2 byte** opcode field which indicates
 
@@ -43,7 +84,8 @@ The mode field contains the
 */
 type ReadRequest struct {
 	Filename string
-	Mode     string // "netascii", "octet", or "mail".
+	Mode     string            // "netascii", "octet", or "mail".
+	Options  map[string]string // RFC 2347 options, nil when none were sent.
 }
 
 func (r ReadRequest) OpCode() OpCode { return RRQ }
@@ -63,6 +105,7 @@ func (r ReadRequest) ToBinary() []byte {
 	readRequest = append(readRequest, 0x00)
 	readRequest = append(readRequest, modeBytes...)
 	readRequest = append(readRequest, 0x00)
+	readRequest = appendOptions(readRequest, r.Options)
 
 	return readRequest
 }
@@ -76,7 +119,8 @@ The mode field contains the
 */
 type WriteRequest struct {
 	Filename string
-	Mode     string // "netascii", "octet", or "mail".
+	Mode     string            // "netascii", "octet", or "mail".
+	Options  map[string]string // RFC 2347 options, nil when none were sent.
 }
 
 func (w WriteRequest) OpCode() OpCode { return WRQ }
@@ -96,6 +140,7 @@ func (r WriteRequest) ToBinary() []byte {
 	readRequest = append(readRequest, 0x00)
 	readRequest = append(readRequest, modeBytes...)
 	readRequest = append(readRequest, 0x00)
+	readRequest = appendOptions(readRequest, r.Options)
 
 	return readRequest
 }
@@ -147,3 +192,58 @@ type Error struct {
 }
 
 func (e Error) OpCode() OpCode { return ERROR }
+
+func (e Error) ToBinary() []byte {
+	errorMessage := make([]byte, 4)
+
+	binary.BigEndian.PutUint16(errorMessage[0:2], uint16(e.OpCode()))
+	binary.BigEndian.PutUint16(errorMessage[2:4], e.ErrorCode)
+	errorMessage = append(errorMessage, []byte(e.ErrorMsg)...)
+	errorMessage = append(errorMessage, 0x00)
+
+	return errorMessage
+}
+
+// Error lets an ERROR packet travel as a Go error, so a failure can carry
+// the code it should be reported to the peer with.
+func (e Error) Error() string {
+	return e.ErrorMsg
+}
+
+// Option acknowledgment packet.
+/*
+The OACK packet is sent by the server in response to a request carrying
+options (RFC 2347). It lists only the options the server accepted, with
+the values it will use for the transfer.
+*/
+type OptionAck struct {
+	Options map[string]string
+}
+
+func (o OptionAck) OpCode() OpCode { return OACK }
+
+func (o OptionAck) ToBinary() []byte {
+	opCodeBuffer := make([]byte, 2)
+	binary.BigEndian.PutUint16(opCodeBuffer, uint16(o.OpCode()))
+
+	return appendOptions(opCodeBuffer, o.Options)
+}
+
+// appendOptions appends each option as a zero-terminated name/value pair.
+// Names are written in sorted order so serialization is deterministic.
+func appendOptions(packet []byte, options map[string]string) []byte {
+	names := make([]string, 0, len(options))
+	for name := range options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		packet = append(packet, []byte(name)...)
+		packet = append(packet, 0x00)
+		packet = append(packet, []byte(options[name])...)
+		packet = append(packet, 0x00)
+	}
+
+	return packet
+}
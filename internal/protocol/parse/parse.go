@@ -1,17 +1,32 @@
 package tftp
 
 import (
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"strings"
 )
 
+// Errors returned by Parse, wrapped with detail, so callers can tell
+// why a datagram was rejected.
+var (
+	ErrEmptyPacket   = errors.New("no data to parse")
+	ErrTruncated     = errors.New("truncated packet")
+	ErrUnknownOpcode = errors.New("unrecognized opcode")
+	ErrInvalidMode   = errors.New("invalid mode, must be one of: netascii, octet, or mail")
+	ErrInvalidOption = errors.New("invalid option")
+)
+
 // Parse parses raw bytes into a TFTP packet.
 // Chosen to be built on top of UDP, and UDP datagram is 1:1 with TFTP packet.
 func Parse(data []byte) (Packet, error) {
 	if len(data) <= 0 {
-		return nil, errors.New("no data to parse")
+		return nil, ErrEmptyPacket
+	}
+
+	if len(data) < 2 {
+		return nil, fmt.Errorf("%w: missing opcode", ErrTruncated)
 	}
 
 	opcode := OpCode(binary.BigEndian.Uint16(data[0:2]))
@@ -22,16 +37,17 @@ func Parse(data []byte) (Packet, error) {
 		ACK:   parseAckRequest,
 		DATA:  parseDataRequest,
 		ERROR: parseErrorRequest,
+		OACK:  parseOptionAck,
 	}
 
 	parser, exists := parsers[opcode]
 	if !exists {
-		return nil, errors.New("unrecognized opcode")
+		return nil, fmt.Errorf("%w: %d", ErrUnknownOpcode, opcode)
 	}
 
 	packet, err := parser(data)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse data of opcode type: %v", opcode)
+		return nil, fmt.Errorf("failed to parse data of opcode type %v: %w", opcode, err)
 	}
 
 	return packet, nil
@@ -49,7 +65,7 @@ func parseWriteRequest(data []byte) (Packet, error) {
 
 func parseReadWriteRequest(data []byte, isRRQ bool) (Packet, error) {
 	if len(data) < 4 {
-		return nil, errors.New("WRQ/RRQ packet is missing opcode and/or required delimiters")
+		return nil, fmt.Errorf("%w: WRQ/RRQ packet is missing opcode and/or required delimiters", ErrTruncated)
 	}
 
 	restPastOpcode := data[2:]
@@ -65,7 +81,7 @@ func parseReadWriteRequest(data []byte, isRRQ bool) (Packet, error) {
 	}
 
 	if endFilenameIdx == -1 {
-		return nil, errors.New("missing zero byte after filename")
+		return nil, fmt.Errorf("%w: missing zero byte after filename", ErrTruncated)
 	}
 
 	filename := string(filenameBytes)
@@ -81,24 +97,73 @@ func parseReadWriteRequest(data []byte, isRRQ bool) (Packet, error) {
 	}
 
 	if endModeIdx == -1 {
-		return nil, errors.New("missing zero byte after mode")
+		return nil, fmt.Errorf("%w: missing zero byte after mode", ErrTruncated)
 	}
 
 	mode := strings.ToLower(string(modeBytes))
 	if _, exists := VALID_MODES[mode]; !exists {
-		return nil, errors.New("invalid mode, must be one of: netascii, octet, or mail")
+		return nil, ErrInvalidMode
+	}
+
+	options, err := parseOptions(restPastOpcode[endFilenameIdx+1+endModeIdx+1:])
+	if err != nil {
+		return nil, err
 	}
 
 	if isRRQ {
-		return ReadRequest{Filename: filename, Mode: mode}, nil
+		return ReadRequest{Filename: filename, Mode: mode, Options: options}, nil
 	} else {
-		return WriteRequest{Filename: filename, Mode: mode}, nil
+		return WriteRequest{Filename: filename, Mode: mode, Options: options}, nil
+	}
+}
+
+func parseOptionAck(data []byte) (Packet, error) {
+	options, err := parseOptions(data[2:])
+	if err != nil {
+		return nil, err
 	}
+
+	return OptionAck{Options: options}, nil
+}
+
+// parseOptions parses the RFC 2347 name/value pairs trailing a request or OACK.
+// Option names are case-insensitive and are returned lowercased.
+// Returns nil when there are no options.
+func parseOptions(data []byte) (map[string]string, error) {
+	var options map[string]string
+	for len(data) > 0 {
+		endNameIdx := bytes.IndexByte(data, 0x0)
+		if endNameIdx == -1 {
+			return nil, fmt.Errorf("%w: missing zero byte after option name", ErrInvalidOption)
+		}
+		if endNameIdx == 0 {
+			return nil, fmt.Errorf("%w: empty option name", ErrInvalidOption)
+		}
+		name := strings.ToLower(string(data[:endNameIdx]))
+		data = data[endNameIdx+1:]
+
+		endValueIdx := bytes.IndexByte(data, 0x0)
+		if endValueIdx == -1 {
+			return nil, fmt.Errorf("%w: missing zero byte after value of option %s", ErrInvalidOption, name)
+		}
+		value := string(data[:endValueIdx])
+		data = data[endValueIdx+1:]
+
+		if options == nil {
+			options = make(map[string]string)
+		}
+		if _, exists := options[name]; exists {
+			return nil, fmt.Errorf("%w: duplicate option %s", ErrInvalidOption, name)
+		}
+		options[name] = value
+	}
+
+	return options, nil
 }
 
 func parseAckRequest(data []byte) (Packet, error) {
 	if len(data) < 4 {
-		return nil, errors.New("ACK packet is missing opcode and/or required block number")
+		return nil, fmt.Errorf("%w: ACK packet is missing opcode and/or required block number", ErrTruncated)
 	}
 	restPastOpcode := data[2:]
 
@@ -112,7 +177,7 @@ func parseAckRequest(data []byte) (Packet, error) {
 
 func parseDataRequest(data []byte) (Packet, error) {
 	if len(data) < 4 {
-		return nil, errors.New("DATA packet is missing opcode and/or required block number")
+		return nil, fmt.Errorf("%w: DATA packet is missing opcode and/or required block number", ErrTruncated)
 	}
 
 	restPastOpcode := data[2:]
@@ -125,7 +190,7 @@ func parseDataRequest(data []byte) (Packet, error) {
 
 func parseErrorRequest(data []byte) (Packet, error) {
 	if len(data) < 5 {
-		return nil, errors.New("ERROR packet is missing opcode and/or required ErrMsg")
+		return nil, fmt.Errorf("%w: ERROR packet is missing opcode and/or required ErrMsg", ErrTruncated)
 	}
 
 	restPastOpcode := data[2:]
@@ -143,7 +208,7 @@ func parseErrorRequest(data []byte) (Packet, error) {
 	}
 
 	if endErrorMsgIdx == -1 {
-		return nil, errors.New("missing zero byte after error message")
+		return nil, fmt.Errorf("%w: missing zero byte after error message", ErrTruncated)
 	}
 
 	errorMsg := string(errorMsgBytes)
@@ -2,8 +2,11 @@ package test
 
 import (
 	"encoding/binary"
+	"fmt"
 	"math"
 	"math/rand"
+	"os"
+	"syscall"
 	"testing"
 	tftp "tftp/internal/protocol/parse"
 
@@ -16,6 +19,7 @@ const (
 	DATA_UINT16  = uint16(3)
 	ACK_UINT16   = uint16(4)
 	ERROR_UINT16 = uint16(5)
+	OACK_UINT16  = uint16(6)
 )
 
 func TestReadRequest(t *testing.T) {
@@ -117,6 +121,7 @@ func TestErrorMessage(t *testing.T) {
 		{5, "Unknown transfer ID"},
 		{6, "File already exists"},
 		{7, "No such user"},
+		{8, "Option negotiation failed"},
 	}
 
 	for _, tc := range testCases {
@@ -138,6 +143,8 @@ func TestErrorMessage(t *testing.T) {
 			t.Errorf("Failed to parse error packet with code %d: %v", tc.errorCode, err)
 		}
 		assert.Equal(t, expectedPacket, packet)
+		assert.Equal(t, errorMessage, expectedPacket.ToBinary())
+		assert.Equal(t, expectedPacket, tftp.NewError(tc.errorCode))
 	}
 }
 
@@ -166,3 +173,126 @@ func TestDataRequest(t *testing.T) {
 	}
 	assert.Equal(t, expectedPacket, packet)
 }
+
+func TestRequestOptions(t *testing.T) {
+	opCode := tftp.RRQ
+	buffer := make([]byte, 2)
+	binary.BigEndian.PutUint16(buffer, uint16(opCode))
+
+	readRequest := []byte{}
+	readRequest = append(readRequest, buffer...)
+	readRequest = append(readRequest, []byte("foo.txt")...)
+	readRequest = append(readRequest, 0x00)
+	readRequest = append(readRequest, []byte("octet")...)
+	readRequest = append(readRequest, 0x00)
+	readRequest = append(readRequest, []byte("BLKSIZE")...)
+	readRequest = append(readRequest, 0x00)
+	readRequest = append(readRequest, []byte("1428")...)
+	readRequest = append(readRequest, 0x00)
+	readRequest = append(readRequest, []byte("tsize")...)
+	readRequest = append(readRequest, 0x00)
+	readRequest = append(readRequest, []byte("0")...)
+	readRequest = append(readRequest, 0x00)
+
+	expectedPacket := tftp.ReadRequest{
+		Filename: "foo.txt",
+		Mode:     "octet",
+		Options:  map[string]string{"blksize": "1428", "tsize": "0"},
+	}
+	packet, err := tftp.Parse(readRequest)
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, expectedPacket, packet)
+
+	// Serializing and re-parsing must be lossless.
+	writeRequest := tftp.WriteRequest{
+		Filename: "bar.bin",
+		Mode:     "octet",
+		Options:  map[string]string{"blksize": "8192", "tsize": "1048576"},
+	}
+	packet, err = tftp.Parse(writeRequest.ToBinary())
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, writeRequest, packet)
+}
+
+func TestMalformedRequestOptions(t *testing.T) {
+	base := tftp.ReadRequest{Filename: "foo.txt", Mode: "octet"}.ToBinary()
+
+	testCases := [][]byte{
+		append(append([]byte{}, base...), []byte("blksize")...),                             // Unterminated name.
+		append(append([]byte{}, base...), append([]byte("blksize"), 0x00)...),               // Missing value.
+		append(append([]byte{}, base...), append([]byte("blksize\x001428"), 0x00, 0x00)...), // Empty name.
+		append(append([]byte{}, base...), []byte("a\x001\x00A\x002\x00")...),                // Duplicate name.
+	}
+
+	for _, tc := range testCases {
+		_, err := tftp.Parse(tc)
+		assert.Error(t, err)
+	}
+}
+
+func TestOptionAck(t *testing.T) {
+	opCode := tftp.OACK
+	opCodeBuffer := make([]byte, 2)
+	binary.BigEndian.PutUint16(opCodeBuffer, uint16(opCode))
+	assert.Equal(t, OACK_UINT16, binary.BigEndian.Uint16(opCodeBuffer))
+
+	var oackMessage []byte
+	oackMessage = append(oackMessage, opCodeBuffer...)
+	oackMessage = append(oackMessage, []byte("blksize")...)
+	oackMessage = append(oackMessage, 0x00)
+	oackMessage = append(oackMessage, []byte("1024")...)
+	oackMessage = append(oackMessage, 0x00)
+
+	expectedPacket := tftp.OptionAck{Options: map[string]string{"blksize": "1024"}}
+	packet, err := tftp.Parse(oackMessage)
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, expectedPacket, packet)
+	assert.Equal(t, oackMessage, expectedPacket.ToBinary())
+}
+
+func TestErrorFromOS(t *testing.T) {
+	testCases := []struct {
+		err       error
+		errorCode uint16
+	}{
+		{os.ErrNotExist, tftp.ERR_FILE_NOT_FOUND},
+		{&os.PathError{Op: "open", Path: "foo", Err: syscall.ENOENT}, tftp.ERR_FILE_NOT_FOUND},
+		{os.ErrPermission, tftp.ERR_ACCESS_VIOLATION},
+		{&os.PathError{Op: "open", Path: "foo", Err: syscall.EACCES}, tftp.ERR_ACCESS_VIOLATION},
+		{fmt.Errorf("write: %w", syscall.ENOSPC), tftp.ERR_DISK_FULL},
+		{os.ErrExist, tftp.ERR_FILE_EXISTS},
+		{fmt.Errorf("wrapped: %w", tftp.NewError(tftp.ERR_NO_SUCH_USER)), tftp.ERR_NO_SUCH_USER},
+		{os.ErrClosed, tftp.ERR_NOT_DEFINED},
+	}
+
+	for _, tc := range testCases {
+		assert.Equal(t, tc.errorCode, tftp.ErrorFromOS(tc.err).ErrorCode, "%v", tc.err)
+	}
+}
+
+func TestMalformedPackets(t *testing.T) {
+	testCases := []struct {
+		packet []byte
+		err    error
+	}{
+		{[]byte{}, tftp.ErrEmptyPacket},
+		{[]byte{0x00}, tftp.ErrTruncated},
+		{[]byte{0x00, 0x04, 0x00}, tftp.ErrTruncated},
+		{[]byte{0x00, 0x03}, tftp.ErrTruncated},
+		{[]byte{0x00, 0x05, 0x00, 0x01, 'x'}, tftp.ErrTruncated},
+		{[]byte{0x00, 0x07}, tftp.ErrUnknownOpcode},
+		{append([]byte{0x00, 0x01, 'f', 0x00}, []byte("image\x00")...), tftp.ErrInvalidMode},
+		{append([]byte{0x00, 0x06}, []byte("blksize")...), tftp.ErrInvalidOption},
+	}
+
+	for _, tc := range testCases {
+		_, err := tftp.Parse(tc.packet)
+		assert.ErrorIs(t, err, tc.err, "%q", tc.packet)
+	}
+}
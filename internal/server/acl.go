@@ -0,0 +1,222 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"path"
+	"strings"
+	tftp "tftp/internal/protocol/parse"
+)
+
+// ACL decides which hosts may read and write which files. Rules are tried
+// in order and the first one matching a request decides it; a request no
+// rule matches is denied.
+type ACL struct {
+	Rules []ACLRule
+}
+
+// ACLRule allows or denies RRQs, WRQs or both from a network, optionally
+// only for files under a path prefix.
+type ACLRule struct {
+	Allow bool
+
+	// Read and Write select the requests the rule applies to, RRQ and WRQ.
+	Read  bool
+	Write bool
+
+	// Network is matched against the client's address. IPv4 clients of a
+	// dual-stack socket are matched as IPv4.
+	Network netip.Prefix
+
+	// PathPrefix, if not empty, limits the rule to that file or directory,
+	// such as "backups" for every file under backups/.
+	PathPrefix string
+
+	// Line is the line of the rule in the file it was parsed from, or 0.
+	Line int
+}
+
+// LoadACL reads an ACL from the file at name; see ParseACL.
+func LoadACL(name string) (*ACL, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	acl, err := ParseACL(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	return acl, nil
+}
+
+// ParseACL reads an ACL with one rule per line, in the form
+//
+//	allow|deny  read|write|all  <CIDR, address or "any">  [path prefix]
+//
+// Blank lines and text after "#" are ignored. For example:
+//
+//	allow  all    10.0.0.0/8
+//	allow  read   2001:db8::/32
+//	allow  write  192.168.1.0/24  backups
+//	deny   all    any
+func ParseACL(r io.Reader) (*ACL, error) {
+	acl := &ACL{}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+
+		rules, err := parseACLRule(fields, line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		acl.Rules = append(acl.Rules, rules...)
+	}
+
+	return acl, scanner.Err()
+}
+
+// parseACLRule parses the fields of one line. "any" expands to a rule for
+// IPv4 and one for IPv6.
+func parseACLRule(fields []string, line int) ([]ACLRule, error) {
+	if len(fields) < 3 || len(fields) > 4 {
+		return nil, fmt.Errorf("expected action, requests, network and optional path, got %d fields", len(fields))
+	}
+
+	rule := ACLRule{Line: line}
+	switch fields[0] {
+	case "allow":
+		rule.Allow = true
+	case "deny":
+	default:
+		return nil, fmt.Errorf("action %q must be allow or deny", fields[0])
+	}
+
+	switch fields[1] {
+	case "read":
+		rule.Read = true
+	case "write":
+		rule.Write = true
+	case "all":
+		rule.Read, rule.Write = true, true
+	default:
+		return nil, fmt.Errorf("requests %q must be read, write or all", fields[1])
+	}
+
+	if len(fields) == 4 {
+		rule.PathPrefix = path.Clean(fields[3])
+	}
+
+	networks, err := parseNetworks(fields[2])
+	if err != nil {
+		return nil, err
+	}
+
+	rules := make([]ACLRule, 0, len(networks))
+	for _, network := range networks {
+		rule.Network = network
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func parseNetworks(value string) ([]netip.Prefix, error) {
+	if value == "any" {
+		return []netip.Prefix{netip.MustParsePrefix("0.0.0.0/0"), netip.MustParsePrefix("::/0")}, nil
+	}
+
+	if strings.Contains(value, "/") {
+		network, err := netip.ParsePrefix(value)
+		if err != nil {
+			return nil, err
+		}
+		return []netip.Prefix{network.Masked()}, nil
+	}
+
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return nil, err
+	}
+	return []netip.Prefix{netip.PrefixFrom(addr, addr.BitLen())}, nil
+}
+
+// Match returns the first rule matching a request, or nil if none does.
+func (a *ACL) Match(opCode tftp.OpCode, remote net.IP, filename string) *ACLRule {
+	addr, ok := netip.AddrFromSlice(remote)
+	if !ok {
+		return nil
+	}
+	addr = addr.Unmap()
+	cleaned := path.Clean(filename)
+
+	for i := range a.Rules {
+		if a.Rules[i].matches(opCode, addr, cleaned) {
+			return &a.Rules[i]
+		}
+	}
+
+	return nil
+}
+
+func (r *ACLRule) matches(opCode tftp.OpCode, addr netip.Addr, filename string) bool {
+	if opCode == tftp.RRQ && !r.Read || opCode == tftp.WRQ && !r.Write {
+		return false
+	}
+
+	if !r.Network.Contains(addr) {
+		return false
+	}
+
+	return r.PathPrefix == "" || r.PathPrefix == "." || filename == r.PathPrefix || strings.HasPrefix(filename, r.PathPrefix+"/")
+}
+
+func (r *ACLRule) String() string {
+	action := "deny"
+	if r.Allow {
+		action = "allow"
+	}
+
+	requests := "all"
+	if !r.Read {
+		requests = "write"
+	} else if !r.Write {
+		requests = "read"
+	}
+
+	rule := fmt.Sprintf("%s %s %v", action, requests, r.Network)
+	if r.PathPrefix != "" {
+		rule += " " + r.PathPrefix
+	}
+	if r.Line > 0 {
+		rule = fmt.Sprintf("%s (line %d)", rule, r.Line)
+	}
+	return rule
+}
+
+// checkAccess applies the ACL to a request, returning the error to send
+// the client if it is denied.
+func (s *Server) checkAccess(opCode tftp.OpCode, remote *net.UDPAddr, filename string) error {
+	if s.ACL == nil {
+		return nil
+	}
+
+	rule := s.ACL.Match(opCode, remote.IP, filename)
+	if rule == nil {
+		return fmt.Errorf("no ACL rule allows %v: %w", remote.IP, tftp.NewError(tftp.ERR_ACCESS_VIOLATION))
+	}
+	if !rule.Allow {
+		return fmt.Errorf("denied by ACL rule %v: %w", rule, tftp.NewError(tftp.ERR_ACCESS_VIOLATION))
+	}
+
+	return nil
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	tftp "tftp/internal/protocol/parse"
+)
+
+// Backend stores the files a Server reads and writes. Names are taken from
+// requests as sent by the client; a Backend must refuse names that would
+// leave its tree. Errors wrapping a tftp.Error, or os errors such as
+// os.ErrNotExist, are reported to the client with the matching error code.
+type Backend interface {
+	// Open opens the named file for reading and returns its size in bytes.
+	Open(name string) (io.ReadCloser, int64, error)
+
+	// Create opens the named file for writing. The upload only replaces
+	// the file once Commit is called.
+	Create(name string) (WriteFile, error)
+}
+
+// WriteFile receives an upload. Exactly one of Commit or Abort is called
+// when the transfer ends.
+type WriteFile interface {
+	io.Writer
+
+	// Commit stores the data written so far as the file's contents.
+	Commit() error
+
+	// Abort discards a failed upload.
+	Abort() error
+}
+
+// spaceReporter is implemented by backends that can report free space,
+// letting the server refuse uploads that declare a tsize too large to store.
+type spaceReporter interface {
+	// FreeSpace returns the bytes available for uploads, or -1 if unknown.
+	FreeSpace() int64
+}
+
+// errReadOnly rejects uploads to a backend that cannot store them.
+var errReadOnly = tftp.Error{ErrorCode: tftp.ERR_ACCESS_VIOLATION, ErrorMsg: "server is read-only"}
+
+// errIsDirectory rejects reading a directory as if it were a file.
+func errIsDirectory(name string) error {
+	return fmt.Errorf("%s is a directory: %w", name, tftp.NewError(tftp.ERR_ACCESS_VIOLATION))
+}
+
+// BackendHandler serves reads and writes from a Backend, for registering
+// a backend with a ServeMux.
+type BackendHandler struct {
+	Backend Backend
+}
+
+func (h BackendHandler) ServeRead(req *Request) (io.Reader, error) {
+	file, size, err := h.Backend.Open(req.Filename)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sizedReader{ReadCloser: file, size: size}, nil
+}
+
+func (h BackendHandler) ServeWrite(req *Request) (io.Writer, error) {
+	return h.Backend.Create(req.Filename)
+}
+
+func (h BackendHandler) FreeSpace() int64 {
+	if reporter, ok := h.Backend.(spaceReporter); ok {
+		return reporter.FreeSpace()
+	}
+
+	return -1
+}
+
+func (h BackendHandler) Exists(name string) (bool, error) {
+	checker, ok := h.Backend.(existenceChecker)
+	if !ok {
+		return false, fmt.Errorf("backend cannot report whether %s exists: %w", name, tftp.NewError(tftp.ERR_ACCESS_VIOLATION))
+	}
+
+	return checker.Exists(name)
+}
+
+// sizedReader carries the size a Backend reported alongside the file.
+type sizedReader struct {
+	io.ReadCloser
+	size int64
+}
+
+func (r *sizedReader) Size() int64 {
+	return r.size
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	tftp "tftp/internal/protocol/parse"
+)
+
+// FSBackend serves the files of an fs.FS, such as an embed.FS bundle, and
+// refuses every upload with an access violation.
+type FSBackend struct {
+	fsys fs.FS
+}
+
+func NewFSBackend(fsys fs.FS) *FSBackend {
+	return &FSBackend{fsys: fsys}
+}
+
+func (b *FSBackend) Open(name string) (io.ReadCloser, int64, error) {
+	// fs.FS names are unrooted and slash-separated, which also rules out "..".
+	if !fs.ValidPath(name) {
+		return nil, 0, fmt.Errorf("%s is not a valid path: %w", name, tftp.NewError(tftp.ERR_ACCESS_VIOLATION))
+	}
+
+	file, err := b.fsys.Open(name)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+	if info.IsDir() {
+		file.Close()
+		return nil, 0, errIsDirectory(name)
+	}
+
+	return file, info.Size(), nil
+}
+
+func (b *FSBackend) Exists(name string) (bool, error) {
+	if !fs.ValidPath(name) {
+		return false, nil
+	}
+
+	_, err := fs.Stat(b.fsys, name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+func (b *FSBackend) Create(name string) (WriteFile, error) {
+	return nil, fmt.Errorf("refusing to write %s: %w", name, errReadOnly)
+}
@@ -0,0 +1,217 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strings"
+	tftp "tftp/internal/protocol/parse"
+)
+
+// LocalBackend serves files from a directory tree. Requests may not name
+// absolute paths or climb out with "..", and os.Root refuses to follow
+// symlinks that leave the tree, so a request can never touch a file
+// outside the directory.
+type LocalBackend struct {
+	dir  string
+	root *os.Root
+}
+
+// STAGING_DIR is the hidden directory under a LocalBackend's root that
+// uploads are written to until they are committed. Requests cannot name
+// anything inside it, so a partial upload is never served.
+const STAGING_DIR = ".tftp-staging"
+
+// NewLocalBackend opens dir for serving. Close releases it. Uploads left
+// in STAGING_DIR by a server that crashed are discarded.
+func NewLocalBackend(dir string) (*LocalBackend, error) {
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open root %s: %w", dir, err)
+	}
+
+	// A read-only root has nothing staged, so failing here is not an error.
+	root.RemoveAll(STAGING_DIR)
+
+	return &LocalBackend{dir: dir, root: root}, nil
+}
+
+func (b *LocalBackend) Close() error {
+	return b.root.Close()
+}
+
+// Open opens the named file for reading, following symlinks inside the root.
+func (b *LocalBackend) Open(name string) (io.ReadCloser, int64, error) {
+	if err := checkLocalName(name); err != nil {
+		return nil, 0, err
+	}
+
+	file, err := b.root.Open(name)
+	if err != nil {
+		return nil, 0, confined(name, err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, confined(name, err)
+	}
+	if info.IsDir() {
+		file.Close()
+		return nil, 0, errIsDirectory(name)
+	}
+
+	return file, info.Size(), nil
+}
+
+// Create starts an upload to the named file. Data goes to a temporary file
+// in STAGING_DIR, which Commit syncs and renames over the target, so
+// a failed upload leaves any existing file untouched. A symlink at the
+// target is replaced rather than followed.
+func (b *LocalBackend) Create(name string) (WriteFile, error) {
+	if err := checkLocalName(name); err != nil {
+		return nil, err
+	}
+
+	// Opening an existing target checks it could be overwritten in place:
+	// it is not a directory, is writable, and no symlink leads out of the root.
+	if existing, err := b.root.OpenFile(name, os.O_WRONLY, 0); err == nil {
+		existing.Close()
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, confined(name, err)
+	}
+
+	// Directories are not created, so an upload into a missing one is
+	// refused now rather than when it is committed.
+	if _, err := b.root.Stat(filepath.Dir(name)); err != nil {
+		return nil, confined(name, err)
+	}
+
+	file, tempName, err := b.createTemp(name)
+	if err != nil {
+		return nil, confined(name, err)
+	}
+
+	return &localWriteFile{File: file, root: b.root, name: name, tempName: tempName}, nil
+}
+
+// createTemp creates a new file in STAGING_DIR for an upload to name.
+func (b *LocalBackend) createTemp(name string) (*os.File, string, error) {
+	if err := b.root.MkdirAll(STAGING_DIR, 0o700); err != nil {
+		return nil, "", err
+	}
+
+	base := filepath.Base(name)
+	for {
+		tempName := filepath.Join(STAGING_DIR, fmt.Sprintf("%s.%d.tmp", base, rand.Uint32()))
+		file, err := b.root.OpenFile(tempName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o666)
+		if errors.Is(err, os.ErrExist) {
+			continue
+		}
+
+		return file, tempName, err
+	}
+}
+
+// Exists reports whether anything, even a dangling symlink, is at name.
+func (b *LocalBackend) Exists(name string) (bool, error) {
+	if err := checkLocalName(name); err != nil {
+		return false, err
+	}
+
+	_, err := b.root.Lstat(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+
+	return err == nil, confined(name, err)
+}
+
+// FreeSpace returns the bytes available on the filesystem holding the root.
+func (b *LocalBackend) FreeSpace() int64 {
+	return freeSpace(b.dir)
+}
+
+type localWriteFile struct {
+	*os.File
+	root     *os.Root
+	name     string
+	tempName string
+}
+
+// Commit makes the upload durable, then replaces the target with it.
+func (f *localWriteFile) Commit() error {
+	// A replaced file keeps its permissions rather than taking those the
+	// umask gave the temporary file.
+	if info, err := f.root.Stat(f.name); err == nil {
+		if err := f.Chmod(info.Mode().Perm()); err != nil {
+			return errors.Join(err, f.Abort())
+		}
+	}
+
+	if err := f.Sync(); err != nil {
+		return errors.Join(err, f.Abort())
+	}
+	if err := f.Close(); err != nil {
+		return errors.Join(err, f.root.Remove(f.tempName))
+	}
+	if err := f.root.Rename(f.tempName, f.name); err != nil {
+		return errors.Join(err, f.root.Remove(f.tempName))
+	}
+
+	// Sync the directory so the rename survives a crash. Not every
+	// platform can sync a directory, so failing to is not an error.
+	if dir, err := f.root.Open(filepath.Dir(f.name)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+
+	return nil
+}
+
+// Abort discards the temporary file, leaving the target as it was.
+func (f *localWriteFile) Abort() error {
+	return errors.Join(f.Close(), f.root.Remove(f.tempName))
+}
+
+// checkLocalName applies checkName, and rejects names inside STAGING_DIR.
+func checkLocalName(name string) error {
+	if err := checkName(name); err != nil {
+		return err
+	}
+
+	first, _, _ := strings.Cut(filepath.ToSlash(filepath.Clean(name)), "/")
+	if strings.EqualFold(first, STAGING_DIR) {
+		return fmt.Errorf("%s is in the staging directory: %w", name, tftp.NewError(tftp.ERR_ACCESS_VIOLATION))
+	}
+
+	return nil
+}
+
+// checkName rejects names that are absolute or climb above the root.
+func checkName(name string) error {
+	if !filepath.IsLocal(name) {
+		return fmt.Errorf("%s leaves the root: %w", name, tftp.NewError(tftp.ERR_ACCESS_VIOLATION))
+	}
+
+	return nil
+}
+
+// confined maps an os.Root failure to the error reported to the client.
+// os.Root does not export the error it returns for a symlink leading out of
+// the root, so any failure without a more specific TFTP error code is
+// reported as an access violation.
+func confined(name string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if tftp.ErrorFromOS(err).ErrorCode != tftp.ERR_NOT_DEFINED {
+		return err
+	}
+
+	return fmt.Errorf("%s: %w: %w", name, tftp.NewError(tftp.ERR_ACCESS_VIOLATION), err)
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// MemoryBackend keeps files in memory, for tests and throwaway servers.
+// Uploads become visible to readers once they complete.
+type MemoryBackend struct {
+	mu    sync.RWMutex
+	files map[string][]byte
+}
+
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{files: make(map[string][]byte)}
+}
+
+// Put stores data as the named file, replacing any existing contents.
+func (b *MemoryBackend) Put(name string, data []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.files[name] = bytes.Clone(data)
+}
+
+// Get returns the contents of the named file and whether it exists.
+func (b *MemoryBackend) Get(name string) ([]byte, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	data, ok := b.files[name]
+	return bytes.Clone(data), ok
+}
+
+func (b *MemoryBackend) Exists(name string) (bool, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	_, ok := b.files[name]
+	return ok, nil
+}
+
+func (b *MemoryBackend) Open(name string) (io.ReadCloser, int64, error) {
+	if err := checkName(name); err != nil {
+		return nil, 0, err
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	// Stored slices are never modified, so readers can share them.
+	data, ok := b.files[name]
+	if !ok {
+		return nil, 0, fmt.Errorf("%s: %w", name, os.ErrNotExist)
+	}
+
+	return io.NopCloser(bytes.NewReader(data)), int64(len(data)), nil
+}
+
+func (b *MemoryBackend) Create(name string) (WriteFile, error) {
+	if err := checkName(name); err != nil {
+		return nil, err
+	}
+
+	return &memoryWriteFile{backend: b, name: name}, nil
+}
+
+type memoryWriteFile struct {
+	bytes.Buffer
+	backend *MemoryBackend
+	name    string
+}
+
+func (f *memoryWriteFile) Commit() error {
+	f.backend.mu.Lock()
+	defer f.backend.mu.Unlock()
+
+	f.backend.files[f.name] = f.Bytes()
+	return nil
+}
+
+func (f *memoryWriteFile) Abort() error {
+	f.Reset()
+	return nil
+}
@@ -0,0 +1,16 @@
+//go:build linux || darwin
+
+package server
+
+import "syscall"
+
+// freeSpace returns the bytes available to unprivileged users on the
+// filesystem holding path, or -1 if it cannot be determined.
+func freeSpace(path string) int64 {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return -1
+	}
+
+	return int64(stat.Bavail) * int64(stat.Bsize)
+}
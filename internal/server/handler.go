@@ -0,0 +1,104 @@
+package server
+
+import (
+	"io"
+	"net"
+	"os"
+)
+
+// Request describes an RRQ or WRQ being handed to a handler.
+type Request struct {
+	// Filename is the file named by the client, as sent. Handlers that map
+	// it onto storage must refuse names that leave their tree.
+	Filename string
+
+	// Mode is tftp.MODE_OCTET or tftp.MODE_NETASCII. The server translates
+	// netascii itself, so handlers always deal in local bytes.
+	Mode string
+
+	// Options are the RFC 2347 options the client requested, before negotiation.
+	Options map[string]string
+
+	RemoteAddr *net.UDPAddr
+}
+
+// ReadHandler serves RRQs. ServeRead returns the contents of the requested
+// file, or an error sent to the client: a tftp.Error, or an error wrapping
+// one or an os error such as os.ErrNotExist, picks the error code.
+//
+// If the reader has a Size() int64 method, as *bytes.Reader, *strings.Reader
+// and *io.SectionReader do, or is an *os.File, its size is reported to
+// clients asking for tsize and no more than that is sent. If it is an
+// io.Closer it is closed when the transfer ends.
+type ReadHandler interface {
+	ServeRead(req *Request) (io.Reader, error)
+}
+
+// WriteHandler serves WRQs. ServeWrite returns where the upload is written,
+// or an error sent to the client as for ReadHandler.
+//
+// When the transfer ends the writer's Commit() error method is called on
+// success and its Abort() error method on failure, if it has them;
+// otherwise a writer that is an io.Closer is closed. Commit runs before the
+// final block is acknowledged, and an error from it is sent to the client
+// instead.
+type WriteHandler interface {
+	ServeWrite(req *Request) (io.Writer, error)
+}
+
+// ReadHandlerFunc adapts a function to a ReadHandler.
+type ReadHandlerFunc func(req *Request) (io.Reader, error)
+
+func (f ReadHandlerFunc) ServeRead(req *Request) (io.Reader, error) {
+	return f(req)
+}
+
+// WriteHandlerFunc adapts a function to a WriteHandler.
+type WriteHandlerFunc func(req *Request) (io.Writer, error)
+
+func (f WriteHandlerFunc) ServeWrite(req *Request) (io.Writer, error) {
+	return f(req)
+}
+
+// readSize returns the number of bytes r will deliver, or -1 if unknown.
+func readSize(r io.Reader) int64 {
+	switch r := r.(type) {
+	case interface{ Size() int64 }:
+		return r.Size()
+	case *os.File:
+		info, err := r.Stat()
+		if err != nil || !info.Mode().IsRegular() {
+			return -1
+		}
+		return info.Size()
+	default:
+		return -1
+	}
+}
+
+// finishRead releases the reader a ReadHandler returned.
+func finishRead(r io.Reader) error {
+	if closer, ok := r.(io.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
+}
+
+// finishWrite commits or discards the writer a WriteHandler returned.
+func finishWrite(w io.Writer, failed bool) error {
+	committer, canCommit := w.(interface{ Commit() error })
+	aborter, canAbort := w.(interface{ Abort() error })
+	switch {
+	case !failed && canCommit:
+		return committer.Commit()
+	case failed && canAbort:
+		return aborter.Abort()
+	}
+
+	if closer, ok := w.(io.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
+}
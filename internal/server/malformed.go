@@ -0,0 +1,111 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"net"
+	"sync"
+	tftp "tftp/internal/protocol/parse"
+	"time"
+)
+
+// Reasons a datagram arriving at the listener is counted as malformed.
+const (
+	REASON_EMPTY          = "empty"
+	REASON_TRUNCATED      = "truncated"
+	REASON_UNKNOWN_OPCODE = "unknown opcode"
+	REASON_INVALID_MODE   = "invalid mode"
+	REASON_INVALID_OPTION = "invalid option"
+	REASON_NOT_A_REQUEST  = "not a request" // DATA, ACK or OACK sent to the listener.
+	REASON_OTHER          = "other"
+)
+
+// Replies to malformed packets are limited to malformedReplyRate per second,
+// with bursts of up to malformedReplyBurst, so a flood of garbage (possibly
+// with spoofed sources) cannot be reflected at full rate.
+const (
+	malformedReplyRate  = 10
+	malformedReplyBurst = 20
+)
+
+// malformedReason classifies an error returned by tftp.Parse.
+func malformedReason(err error) string {
+	switch {
+	case errors.Is(err, tftp.ErrEmptyPacket):
+		return REASON_EMPTY
+	case errors.Is(err, tftp.ErrTruncated):
+		return REASON_TRUNCATED
+	case errors.Is(err, tftp.ErrUnknownOpcode):
+		return REASON_UNKNOWN_OPCODE
+	case errors.Is(err, tftp.ErrInvalidMode):
+		return REASON_INVALID_MODE
+	case errors.Is(err, tftp.ErrInvalidOption):
+		return REASON_INVALID_OPTION
+	default:
+		return REASON_OTHER
+	}
+}
+
+// malformedCounter counts rejected datagrams by reason and rate limits the
+// ERROR replies sent for them.
+type malformedCounter struct {
+	mu     sync.Mutex
+	counts map[string]uint64
+
+	// Token bucket for replies.
+	tokens float64
+	last   time.Time
+}
+
+func newMalformedCounter() *malformedCounter {
+	return &malformedCounter{counts: make(map[string]uint64), tokens: malformedReplyBurst}
+}
+
+// record counts a malformed datagram and reports whether a reply may be sent.
+func (m *malformedCounter) record(reason string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.counts[reason]++
+
+	now := time.Now()
+	if !m.last.IsZero() {
+		m.tokens += now.Sub(m.last).Seconds() * malformedReplyRate
+		m.tokens = min(m.tokens, malformedReplyBurst)
+	}
+	m.last = now
+
+	if m.tokens < 1 {
+		return false
+	}
+	m.tokens--
+	return true
+}
+
+func (m *malformedCounter) snapshot() map[string]uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	counts := make(map[string]uint64, len(m.counts))
+	for reason, count := range m.counts {
+		counts[reason] = count
+	}
+
+	return counts
+}
+
+// MalformedPackets returns how many datagrams the listener rejected, by reason.
+func (s *Server) MalformedPackets() map[string]uint64 {
+	return s.malformed.snapshot()
+}
+
+// rejectMalformed counts a bad datagram and, unless replies are being rate
+// limited, answers it with an "illegal TFTP operation" ERROR.
+func (s *Server) rejectMalformed(local, remote *net.UDPAddr, reason string, err error) {
+	if !s.malformed.record(reason) {
+		return
+	}
+
+	log.Printf("rejecting malformed packet from %v (%s): %v", remote, reason, err)
+	sendError(local, remote, tftp.NewError(tftp.ERR_ILLEGAL_OPERATION))
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"sync"
+	tftp "tftp/internal/protocol/parse"
+)
+
+// ServeMux routes requests to handlers by filename. A pattern ending in "/"
+// matches every file under that directory, such as "pxelinux.cfg/"; any
+// other pattern is a path.Match glob, such as "*.bin" or "firmware/v?/*".
+// Patterns are tried in the order they were registered and the first match
+// wins. Filenames are matched as cleaned paths, so "pxelinux.cfg/../key"
+// is matched as "key", and one that climbs out of the tree with ".." is
+// refused with an access violation. A read nothing matches is answered
+// with file not found, and a write with an access violation.
+type ServeMux struct {
+	mu     sync.RWMutex
+	reads  []muxEntry[ReadHandler]
+	writes []muxEntry[WriteHandler]
+}
+
+type muxEntry[H any] struct {
+	pattern string
+	handler H
+}
+
+func NewServeMux() *ServeMux {
+	return &ServeMux{}
+}
+
+// HandleRead registers handler for RRQs whose filename matches pattern.
+// It panics if pattern is malformed.
+func (m *ServeMux) HandleRead(pattern string, handler ReadHandler) {
+	checkPattern(pattern)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.reads = append(m.reads, muxEntry[ReadHandler]{pattern: pattern, handler: handler})
+}
+
+// HandleReadFunc registers a function for RRQs whose filename matches pattern.
+func (m *ServeMux) HandleReadFunc(pattern string, handler func(req *Request) (io.Reader, error)) {
+	m.HandleRead(pattern, ReadHandlerFunc(handler))
+}
+
+// HandleWrite registers handler for WRQs whose filename matches pattern.
+// It panics if pattern is malformed.
+func (m *ServeMux) HandleWrite(pattern string, handler WriteHandler) {
+	checkPattern(pattern)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.writes = append(m.writes, muxEntry[WriteHandler]{pattern: pattern, handler: handler})
+}
+
+// HandleWriteFunc registers a function for WRQs whose filename matches pattern.
+func (m *ServeMux) HandleWriteFunc(pattern string, handler func(req *Request) (io.Writer, error)) {
+	m.HandleWrite(pattern, WriteHandlerFunc(handler))
+}
+
+func (m *ServeMux) ServeRead(req *Request) (io.Reader, error) {
+	name, err := matchName(req.Filename)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	handler, ok := match(m.reads, name)
+	m.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("no handler for %s: %w", req.Filename, tftp.NewError(tftp.ERR_FILE_NOT_FOUND))
+	}
+
+	return handler.ServeRead(req)
+}
+
+func (m *ServeMux) ServeWrite(req *Request) (io.Writer, error) {
+	name, err := matchName(req.Filename)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	handler, ok := match(m.writes, name)
+	m.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("no handler for %s: %w", req.Filename, tftp.NewError(tftp.ERR_ACCESS_VIOLATION))
+	}
+
+	return handler.ServeWrite(req)
+}
+
+// Exists asks the write handler matching name whether the file exists, so
+// a Server routing WRQs through the mux can apply the create-only and
+// overwrite-only write policies.
+func (m *ServeMux) Exists(name string) (bool, error) {
+	cleaned, err := matchName(name)
+	if err != nil {
+		return false, err
+	}
+
+	m.mu.RLock()
+	handler, ok := match(m.writes, cleaned)
+	m.mu.RUnlock()
+	if !ok {
+		return false, fmt.Errorf("no handler for %s: %w", name, tftp.NewError(tftp.ERR_ACCESS_VIOLATION))
+	}
+
+	checker, ok := handler.(existenceChecker)
+	if !ok {
+		return false, fmt.Errorf("handler cannot report whether %s exists: %w", name, tftp.NewError(tftp.ERR_ACCESS_VIOLATION))
+	}
+
+	return checker.Exists(name)
+}
+
+// FreeSpace returns the least free space reported by the write handlers,
+// or -1 if none reports it. The server checks a declared tsize before it
+// knows which handler the upload goes to, so the smallest is the only safe
+// bound.
+func (m *ServeMux) FreeSpace() int64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	free := int64(-1)
+	for _, entry := range m.writes {
+		reporter, ok := entry.handler.(spaceReporter)
+		if !ok {
+			continue
+		}
+		if space := reporter.FreeSpace(); space >= 0 && (free < 0 || space < free) {
+			free = space
+		}
+	}
+
+	return free
+}
+
+// matchName returns the name patterns are matched against: filename as the
+// handler will resolve it, so ".." cannot step out of a directory pattern.
+func matchName(filename string) (string, error) {
+	cleaned := path.Clean(filename)
+	if cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", fmt.Errorf("%s is outside the served tree: %w", filename, tftp.NewError(tftp.ERR_ACCESS_VIOLATION))
+	}
+
+	return cleaned, nil
+}
+
+// match returns the handler of the first entry whose pattern matches name.
+func match[H any](entries []muxEntry[H], name string) (H, bool) {
+	for _, entry := range entries {
+		if patternMatches(entry.pattern, name) {
+			return entry.handler, true
+		}
+	}
+
+	var none H
+	return none, false
+}
+
+func patternMatches(pattern, name string) bool {
+	if strings.HasSuffix(pattern, "/") {
+		return strings.HasPrefix(name, pattern)
+	}
+
+	matched, _ := path.Match(pattern, name)
+	return matched
+}
+
+func checkPattern(pattern string) {
+	if pattern == "" {
+		panic("tftp: empty pattern")
+	}
+
+	if _, err := path.Match(pattern, ""); err != nil {
+		panic(fmt.Sprintf("tftp: invalid pattern %q: %v", pattern, err))
+	}
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"strconv"
+	protocol "tftp/internal/protocol/parse"
+	"tftp/internal/transfer"
+	"time"
+)
+
+// negotiation collects what the server agreed to for one request.
+type negotiation struct {
+	config transfer.Config
+
+	// isWrite is set for a WRQ, where tsize is declared by the client
+	// rather than reported by the server.
+	isWrite bool
+	// fileSize is the size of the file an RRQ reads, reported for tsize,
+	// or -1 when unknown, in which case tsize is left unacknowledged.
+	fileSize int64
+	// uploadSize is the size a WRQ declared with tsize, or -1 when unknown.
+	uploadSize int64
+}
+
+func newReadNegotiation(config transfer.Config, fileSize int64) *negotiation {
+	return &negotiation{config: config, fileSize: fileSize, uploadSize: -1}
+}
+
+func newWriteNegotiation(config transfer.Config) *negotiation {
+	return &negotiation{config: config, isWrite: true, uploadSize: -1}
+}
+
+// optionNegotiators maps an RFC 2347 option name to a function deciding
+// whether the server accepts the requested value, and which value it will use.
+var optionNegotiators = map[string]func(value string, n *negotiation) (string, bool){
+	protocol.OPTION_BLKSIZE:    negotiateBlockSize,
+	protocol.OPTION_WINDOWSIZE: negotiateWindowSize,
+	protocol.OPTION_TSIZE:      negotiateTransferSize,
+	protocol.OPTION_TIMEOUT:    negotiateTimeout,
+	protocol.OPTION_ROLLOVER:   negotiateRollover,
+}
+
+// negotiate returns the subset of the requested options the server accepts,
+// recording the transfer parameters they imply.
+// Unrecognized or unacceptable options are dropped, per RFC 2347.
+// An empty result means the transfer proceeds without an OACK.
+func (n *negotiation) negotiate(requested map[string]string) map[string]string {
+	accepted := make(map[string]string)
+	for name, value := range requested {
+		negotiator, exists := optionNegotiators[name]
+		if !exists {
+			continue
+		}
+
+		if acceptedValue, ok := negotiator(value, n); ok {
+			accepted[name] = acceptedValue
+		}
+	}
+
+	return accepted
+}
+
+// negotiateBlockSize accepts a blksize in [8, 65464] (RFC 2348).
+// Larger requests are answered with the maximum instead of being refused.
+func negotiateBlockSize(value string, n *negotiation) (string, bool) {
+	blockSize, err := strconv.Atoi(value)
+	if err != nil || blockSize < protocol.MIN_BLOCK_SIZE {
+		return "", false
+	}
+
+	if blockSize > protocol.MAX_BLOCK_SIZE {
+		blockSize = protocol.MAX_BLOCK_SIZE
+	}
+
+	n.config.BlockSize = blockSize
+	return strconv.Itoa(blockSize), true
+}
+
+// negotiateWindowSize accepts a windowsize in [1, 65535] (RFC 7440),
+// answering with protocol.WINDOW_SIZE_LIMIT when more is requested.
+func negotiateWindowSize(value string, n *negotiation) (string, bool) {
+	windowSize, err := strconv.Atoi(value)
+	if err != nil || windowSize < protocol.MIN_WINDOW_SIZE || windowSize > protocol.MAX_WINDOW_SIZE {
+		return "", false
+	}
+
+	windowSize = min(windowSize, protocol.WINDOW_SIZE_LIMIT)
+
+	n.config.WindowSize = windowSize
+	return strconv.Itoa(windowSize), true
+}
+
+// negotiateTransferSize answers an RRQ's tsize with the size of the file,
+// and echoes the size a WRQ declares so it can be checked before accepting (RFC 2349).
+func negotiateTransferSize(value string, n *negotiation) (string, bool) {
+	size, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || size < 0 {
+		return "", false
+	}
+
+	if n.isWrite {
+		n.uploadSize = size
+		return value, true
+	}
+
+	if n.fileSize < 0 {
+		return "", false
+	}
+
+	return strconv.FormatInt(n.fileSize, 10), true
+}
+
+// negotiateTimeout accepts a per-packet timeout of 1 to 255 seconds (RFC 2349).
+// The value must be echoed unchanged.
+func negotiateTimeout(value string, n *negotiation) (string, bool) {
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds < protocol.MIN_TIMEOUT || seconds > protocol.MAX_TIMEOUT {
+		return "", false
+	}
+
+	n.config.Timeout = time.Duration(seconds) * time.Second
+	return value, true
+}
+
+// negotiateRollover accepts the block number a client wants to follow 65535.
+func negotiateRollover(value string, n *negotiation) (string, bool) {
+	switch value {
+	case "0":
+		n.config.Rollover = 0
+	case "1":
+		n.config.Rollover = 1
+	default:
+		return "", false
+	}
+
+	return value, true
+}
@@ -0,0 +1,24 @@
+package server
+
+import "net"
+
+// requestReader reads datagrams arriving at a listener, along with the
+// local address each was sent to where the platform reports it. On a
+// multi-homed host a listener bound to a wildcard address receives requests
+// for several addresses, and each session must answer from the right one.
+type requestReader interface {
+	// ReadRequest returns the datagram's length and sender, and the local
+	// address it was sent to, or nil if unknown.
+	ReadRequest(buf []byte) (int, net.Addr, *net.UDPAddr, error)
+}
+
+// plainReader reads datagrams without their destination address, leaving
+// sessions to answer from the listener's own address.
+type plainReader struct {
+	conn net.PacketConn
+}
+
+func (r plainReader) ReadRequest(buf []byte) (int, net.Addr, *net.UDPAddr, error) {
+	n, addr, err := r.conn.ReadFrom(buf)
+	return n, addr, nil, err
+}
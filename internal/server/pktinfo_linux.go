@@ -0,0 +1,97 @@
+//go:build linux
+
+package server
+
+import (
+	"encoding/binary"
+	"log"
+	"net"
+	"syscall"
+)
+
+// newRequestReader asks the kernel to report the destination address of
+// each datagram (IP_PKTINFO, IPV6_RECVPKTINFO), falling back to a
+// plainReader if conn is not a UDP socket or the options are refused.
+func newRequestReader(conn net.PacketConn) requestReader {
+	udpConn, ok := conn.(*net.UDPConn)
+	if !ok {
+		return plainReader{conn: conn}
+	}
+
+	raw, err := udpConn.SyscallConn()
+	if err != nil {
+		return plainReader{conn: conn}
+	}
+
+	// An IPv6 socket can be dual-stack, so ask for both; the option for the
+	// other family fails harmlessly on a single-family socket.
+	enabled := false
+	err = raw.Control(func(fd uintptr) {
+		err4 := syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_PKTINFO, 1)
+		err6 := syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_RECVPKTINFO, 1)
+		enabled = err4 == nil || err6 == nil
+	})
+	if err != nil || !enabled {
+		log.Printf("replies from %v may use a different source address: cannot enable packet info", conn.LocalAddr())
+		return plainReader{conn: conn}
+	}
+
+	return &pktinfoReader{conn: udpConn, oob: make([]byte, 128)}
+}
+
+// pktinfoReader reads datagrams with the IP_PKTINFO or IPV6_PKTINFO
+// control message carrying their destination address.
+type pktinfoReader struct {
+	conn *net.UDPConn
+	oob  []byte
+}
+
+func (r *pktinfoReader) ReadRequest(buf []byte) (int, net.Addr, *net.UDPAddr, error) {
+	n, oobn, _, remote, err := r.conn.ReadMsgUDP(buf, r.oob)
+	if err != nil {
+		return n, nil, nil, err
+	}
+
+	local := packetDestination(r.oob[:oobn])
+	if local != nil {
+		local.Port = r.conn.LocalAddr().(*net.UDPAddr).Port
+	}
+	return n, remote, local, nil
+}
+
+// packetDestination decodes the local address from the control messages
+// of a datagram, or returns nil if none is usable for replies.
+func packetDestination(oob []byte) *net.UDPAddr {
+	messages, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil
+	}
+
+	for _, message := range messages {
+		switch {
+		case message.Header.Level == syscall.IPPROTO_IP && message.Header.Type == syscall.IP_PKTINFO &&
+			len(message.Data) >= syscall.SizeofInet4Pktinfo:
+			// struct in_pktinfo { int ipi_ifindex; in_addr ipi_spec_dst; in_addr ipi_addr; }
+			// ipi_spec_dst is the local address, even for a broadcast request.
+			return &net.UDPAddr{IP: net.IPv4(message.Data[4], message.Data[5], message.Data[6], message.Data[7])}
+		case message.Header.Level == syscall.IPPROTO_IPV6 && message.Header.Type == syscall.IPV6_PKTINFO &&
+			len(message.Data) >= syscall.SizeofInet6Pktinfo:
+			// struct in6_pktinfo { in6_addr ipi6_addr; unsigned int ipi6_ifindex; }
+			ip := net.IP(append([]byte(nil), message.Data[:net.IPv6len]...))
+			if ip.IsMulticast() {
+				return nil
+			}
+
+			local := &net.UDPAddr{IP: ip}
+			if ip.IsLinkLocalUnicast() {
+				ifindex := binary.NativeEndian.Uint32(message.Data[net.IPv6len:])
+				if iface, err := net.InterfaceByIndex(int(ifindex)); err == nil {
+					local.Zone = iface.Name
+				}
+			}
+			return local
+		}
+	}
+
+	return nil
+}
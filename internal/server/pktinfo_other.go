@@ -0,0 +1,11 @@
+//go:build !linux
+
+package server
+
+import "net"
+
+// newRequestReader reads datagrams without their destination address,
+// which is only available on Linux.
+func newRequestReader(conn net.PacketConn) requestReader {
+	return plainReader{conn: conn}
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"fmt"
+	"path"
+	tftp "tftp/internal/protocol/parse"
+)
+
+// WritePolicy decides which WRQs the server accepts, by whether the target exists.
+type WritePolicy int
+
+const (
+	WRITE_ALLOW_ALL      WritePolicy = iota // Create new files and overwrite existing ones.
+	WRITE_READ_ONLY                         // Refuse every WRQ with an access violation.
+	WRITE_CREATE_ONLY                       // Refuse to overwrite, with file already exists.
+	WRITE_OVERWRITE_ONLY                    // Refuse to create, with file not found, as classic tftpd without -c.
+)
+
+var writePolicyNames = map[WritePolicy]string{
+	WRITE_ALLOW_ALL:      "allow-all",
+	WRITE_READ_ONLY:      "read-only",
+	WRITE_CREATE_ONLY:    "create-only",
+	WRITE_OVERWRITE_ONLY: "overwrite-only",
+}
+
+func (p WritePolicy) String() string {
+	if name, ok := writePolicyNames[p]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("WritePolicy(%d)", int(p))
+}
+
+// ParseWritePolicy returns the policy named by String.
+func ParseWritePolicy(name string) (WritePolicy, error) {
+	for policy, policyName := range writePolicyNames {
+		if policyName == name {
+			return policy, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown write policy %q", name)
+}
+
+// existenceChecker is implemented by write handlers that can report whether
+// a file exists, which the create-only and overwrite-only policies need.
+type existenceChecker interface {
+	Exists(name string) (bool, error)
+}
+
+// checkWrite applies WritePolicy, WriteAllow and WriteDeny to a WRQ for name,
+// returning the error to send the client if the upload is refused.
+func (s *Server) checkWrite(name string) error {
+	if s.WritePolicy == WRITE_READ_ONLY {
+		return errReadOnly
+	}
+
+	// Match the name as the backend will resolve it, so "./x" or "a//x"
+	// cannot slip past a pattern for "x" or "a/x".
+	cleaned := path.Clean(name)
+	if matchesAny(s.WriteDeny, cleaned) {
+		return fmt.Errorf("%s matches a denied pattern: %w", name, tftp.NewError(tftp.ERR_ACCESS_VIOLATION))
+	}
+	if len(s.WriteAllow) > 0 && !matchesAny(s.WriteAllow, cleaned) {
+		return fmt.Errorf("%s matches no allowed pattern: %w", name, tftp.NewError(tftp.ERR_ACCESS_VIOLATION))
+	}
+
+	if s.WritePolicy == WRITE_ALLOW_ALL {
+		return nil
+	}
+
+	checker, ok := s.writes.(existenceChecker)
+	if !ok {
+		return fmt.Errorf("%v policy needs a handler that reports existing files: %w", s.WritePolicy, tftp.NewError(tftp.ERR_ACCESS_VIOLATION))
+	}
+	exists, err := checker.Exists(name)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case s.WritePolicy == WRITE_CREATE_ONLY && exists:
+		return tftp.NewError(tftp.ERR_FILE_EXISTS)
+	case s.WritePolicy == WRITE_OVERWRITE_ONLY && !exists:
+		return tftp.NewError(tftp.ERR_FILE_NOT_FOUND)
+	default:
+		return nil
+	}
+}
+
+// reserveCreate claims name for an upload under WRITE_CREATE_ONLY until the
+// returned function is called, once the upload is committed or discarded.
+// checkWrite then runs under the claim, so of two WRQs racing to create the
+// same file only one can find it missing. Other policies claim nothing.
+func (s *Server) reserveCreate(name string) (func(), error) {
+	if s.WritePolicy != WRITE_CREATE_ONLY {
+		return func() {}, nil
+	}
+
+	cleaned := path.Clean(name)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, busy := s.creating[cleaned]; busy {
+		return nil, fmt.Errorf("%s is already being uploaded: %w", name, tftp.NewError(tftp.ERR_FILE_EXISTS))
+	}
+	s.creating[cleaned] = struct{}{}
+
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.creating, cleaned)
+	}, nil
+}
+
+// matchesAny reports whether name matches one of the path.Match patterns.
+// A malformed pattern matches nothing.
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+
+	return false
+}
@@ -2,14 +2,18 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
-	"os"
-	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"tftp/internal/client"
+	"tftp/internal/netascii"
 	protocol "tftp/internal/protocol/parse"
 	tftp "tftp/internal/protocol/parse"
+	"tftp/internal/transfer"
 	"tftp/internal/utils"
 	"time"
 
@@ -17,49 +21,339 @@ import (
 )
 
 type Server struct {
-	port int
-	root string
-	conn *net.UDPConn
+	addrs     []string
+	reads     ReadHandler
+	writes    WriteHandler
+	malformed *malformedCounter
+
+	// MaxUploadSize rejects a WRQ whose declared tsize exceeds it.
+	// Zero means no limit beyond the free space the backend reports.
+	MaxUploadSize int64
+
+	// Rollover is the block number that follows 65535 in transfers larger
+	// than 32 MB at the default block size: 0 (the default) or 1.
+	// A client may choose with the rollover option instead.
+	Rollover uint16
+
+	// PortRange is where session sockets take their ports, the server's
+	// TIDs. The zero value means utils.DEFAULT_PORT_RANGE. When every port
+	// is in use new requests are refused with a "server busy" ERROR.
+	PortRange utils.PortRange
+
+	// ACL, if set, decides which hosts may read and write which files.
+	// Nil allows every request.
+	ACL *ACL
+
+	// WritePolicy decides whether a WRQ may create or overwrite its target.
+	// The default, WRITE_ALLOW_ALL, accepts both.
+	WritePolicy WritePolicy
+
+	// WriteAllow, if not empty, limits uploads to filenames matching one of
+	// these path.Match patterns, such as "backups/*.cfg".
+	WriteAllow []string
+
+	// WriteDeny refuses uploads to filenames matching any of these
+	// patterns, even if WriteAllow matches them too.
+	WriteDeny []string
+
+	mu         sync.Mutex
+	ports      *utils.PortAllocator
+	listeners  map[net.PacketConn]struct{}
+	inShutdown atomic.Bool
+	// creating holds the cleaned names of create-only uploads in progress.
+	creating map[string]struct{}
+	// sessions tracks running transfers, and cancelSessions aborts them
+	// when Shutdown runs out of time.
+	sessions       sync.WaitGroup
+	activeSessions atomic.Int64
+	sessionsCtx    context.Context
+	cancelSessions context.CancelFunc
 }
 
-func New(port int, root string) *Server {
-	return &Server{port: port, root: root}
+// ErrServerClosed is returned by Serve and ListenAndServe after Shutdown.
+var ErrServerClosed = errors.New("tftp: server closed")
+
+// DEFAULT_ADDRESS is where ListenAndServe listens when no addresses are
+// given: port 69 on the IPv4 loopback interface only. Every file the
+// handlers serve, and with the default WritePolicy every upload, is open
+// to anyone who can reach the server, so it is only exposed to the
+// network when given addresses such as ":69" explicitly.
+const DEFAULT_ADDRESS = "127.0.0.1:69"
+
+// New returns a server for the files in backend, listening on addrs; see
+// ListenAndServe. The caller owns the backend and should close it, if
+// needed, after Shutdown returns.
+func New(addrs []string, backend Backend) *Server {
+	handler := BackendHandler{Backend: backend}
+	return NewWithHandlers(addrs, handler, handler)
 }
 
+// NewWithHandlers returns a server listening on addrs that asks reads for
+// the file of each RRQ and writes for the destination of each WRQ. A nil
+// handler refuses the corresponding requests.
+func NewWithHandlers(addrs []string, reads ReadHandler, writes WriteHandler) *Server {
+	sessionsCtx, cancelSessions := context.WithCancel(context.Background())
+	return &Server{
+		addrs:          addrs,
+		reads:          reads,
+		writes:         writes,
+		malformed:      newMalformedCounter(),
+		listeners:      make(map[net.PacketConn]struct{}),
+		creating:       make(map[string]struct{}),
+		sessionsCtx:    sessionsCtx,
+		cancelSessions: cancelSessions,
+	}
+}
+
+// ListenAndServe listens on each of the server's addresses and serves
+// requests from all of them until Shutdown, returning ErrServerClosed.
+// Addresses are host:port pairs such as "0.0.0.0:69", "[::]:69",
+// "[fe80::1%eth0]:69" for a link-local address on one interface, or ":69"
+// for every interface; DEFAULT_ADDRESS is used if there are none. If a
+// listener fails, the others stop accepting requests and its error is
+// returned once they have.
 func (s *Server) ListenAndServe() error {
-	// Listen for UDP packets
-	// Handle RRQ/WRQ requests
-	// Spawn goroutines for each transfer
-	localAddr := fmt.Sprintf("localhost:%d", s.port)
-	laddr, err := net.ResolveUDPAddr("udp", localAddr)
-	if err != nil {
-		log.Print(err)
-		log.Fatal("failed to resolve UDP addr")
+	if err := s.validate(); err != nil {
+		return err
 	}
 
-	conn, err := net.ListenUDP("udp", laddr)
-	if err != nil {
-		log.Print(err)
-		log.Fatal("failed to create UDP socket:")
+	addrs := s.addrs
+	if len(addrs) == 0 {
+		addrs = []string{DEFAULT_ADDRESS}
+	}
+
+	conns := make([]*net.UDPConn, 0, len(addrs))
+	closeAll := func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}
+	for _, addr := range addrs {
+		laddr, err := net.ResolveUDPAddr("udp", addr)
+		if err != nil {
+			closeAll()
+			return fmt.Errorf("failed to resolve UDP addr %s: %w", addr, err)
+		}
+
+		conn, err := net.ListenUDP("udp", laddr)
+		if err != nil {
+			closeAll()
+			return fmt.Errorf("failed to create UDP socket on %s: %w", addr, err)
+		}
+		conns = append(conns, conn)
+	}
+
+	errs := make(chan error, len(conns))
+	for _, conn := range conns {
+		go func() {
+			errs <- s.Serve(context.Background(), conn)
+		}()
+	}
+
+	// Closing the other sockets stops their loops but, unlike cancelling
+	// their ctx, lets running transfers finish.
+	var first error
+	for range conns {
+		err := <-errs
+		if first == nil {
+			first = err
+			if !errors.Is(err, ErrServerClosed) {
+				closeAll()
+			}
+		}
 	}
-	defer conn.Close()
-	ctx := context.Background()
 
+	return first
+}
+
+// Serve reads requests from conn and runs a session for each RRQ and WRQ
+// until Shutdown is called or ctx is cancelled. Cancelling ctx also cancels
+// the sessions started by this call. Serve closes conn before returning.
+func (s *Server) Serve(ctx context.Context, conn net.PacketConn) error {
+	if err := s.validate(); err != nil {
+		conn.Close()
+		return err
+	}
+	if err := s.trackListener(conn); err != nil {
+		conn.Close()
+		return err
+	}
+	defer s.untrackListener(conn)
+
+	stopListener := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stopListener()
+
+	// Sessions are cancelled by this listener's ctx or by a Shutdown that
+	// runs out of time, but outlive Serve itself so they can drain.
+	var sessions sync.WaitGroup
+	sessionCtx, cancelSessions := context.WithCancel(ctx)
+	stopSessions := context.AfterFunc(s.sessionsCtx, cancelSessions)
+	defer func() {
+		go func() {
+			sessions.Wait()
+			stopSessions()
+			cancelSessions()
+		}()
+	}()
+
+	// Sessions answer from the address the request was sent to, which is
+	// the listener's own unless it is bound to a wildcard address.
+	listenerAddr, _ := conn.LocalAddr().(*net.UDPAddr)
+	reader := newRequestReader(conn)
+
+	// Spawn goroutines for each transfer
 	var buf [client.TFTP_MAX_DATAGRAM_LENGTH]byte
+	backoff := time.Duration(0)
 	for {
-		n, remote, err := conn.ReadFromUDP(buf[:])
+		n, addr, local, err := reader.ReadRequest(buf[:])
+		if s.inShutdown.Load() {
+			return ErrServerClosed
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if errors.Is(err, net.ErrClosed) {
+			return err
+		}
 		if err != nil {
-			log.Fatalf("failed to read from UDP conn: %w\n", err)
+			// Errors such as ICMP-induced ECONNREFUSED or ENOBUFS are transient;
+			// back off briefly instead of spinning on a failing socket.
+			backoff = min(max(2*backoff, 5*time.Millisecond), time.Second)
+			log.Printf("failed to read from UDP conn, retrying in %v: %v\n", backoff, err)
+			time.Sleep(backoff)
+			continue
+		}
+		backoff = 0
+
+		remote, ok := addr.(*net.UDPAddr)
+		if !ok {
+			log.Printf("ignoring packet from non-UDP address %v", addr)
+			continue
+		}
+		if local == nil {
+			local = listenerAddr
 		}
+
 		packet, err := protocol.Parse(buf[:n])
 		if err != nil {
-			log.Fatalf("failed to parase packet %s\n", string(buf[:n]))
+			s.rejectMalformed(local, remote, malformedReason(err), err)
+			continue
+		}
+
+		if !s.trackSession() {
+			return ErrServerClosed
 		}
-		go handlePacket(ctx, remote, packet, s.root)
+		sessions.Add(1)
+		s.activeSessions.Add(1)
+		go func() {
+			defer s.sessions.Done()
+			defer sessions.Done()
+			defer s.activeSessions.Add(-1)
+			s.handlePacket(sessionCtx, local, remote, packet)
+		}()
+	}
+}
+
+// Shutdown stops the server from accepting new requests and waits for
+// running transfers to finish. If ctx ends first, the remaining transfers
+// are cancelled, each peer is sent an ERROR, and ctx's error is returned
+// once they have exited.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.inShutdown.Store(true)
+	for conn := range s.listeners {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		s.sessions.Wait()
+		close(drained)
+	}()
+
+	var err error
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		err = ctx.Err()
+		s.cancelSessions()
+		<-drained
+	}
+
+	return err
+}
+
+// Addrs returns the addresses the server is currently listening on.
+func (s *Server) Addrs() []net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	addrs := make([]net.Addr, 0, len(s.listeners))
+	for conn := range s.listeners {
+		addrs = append(addrs, conn.LocalAddr())
+	}
+	return addrs
+}
+
+// validate reports settings the server cannot run with.
+func (s *Server) validate() error {
+	if s.PortRange != (utils.PortRange{}) {
+		if err := s.PortRange.Validate(); err != nil {
+			return fmt.Errorf("invalid PortRange: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// ActiveSessions returns the number of requests currently being handled.
+func (s *Server) ActiveSessions() int {
+	return int(s.activeSessions.Load())
+}
+
+func (s *Server) trackListener(conn net.PacketConn) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.inShutdown.Load() {
+		return ErrServerClosed
 	}
+	s.listeners[conn] = struct{}{}
+	return nil
 }
 
-func handlePacket(ctx context.Context, remote *net.UDPAddr, packet tftp.Packet, root string) {
+// trackSession counts a new session unless the server is shutting down.
+// Checking and counting under mu keeps Shutdown from starting to wait for
+// sessions in between, which would let this one run unwaited.
+func (s *Server) trackSession() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.inShutdown.Load() {
+		return false
+	}
+	s.sessions.Add(1)
+	return true
+}
+
+func (s *Server) untrackListener(conn net.PacketConn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.listeners, conn)
+	conn.Close()
+}
+
+// handlePacket runs the session for a request that arrived at local from remote.
+func (s *Server) handlePacket(ctx context.Context, local, remote *net.UDPAddr, packet tftp.Packet) {
+	// A bug in one session must not take down the daemon.
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("panic handling %d packet from %v: %v", packet.OpCode(), remote, r)
+		}
+	}()
+
 	switch packet.OpCode() {
 	case tftp.RRQ:
 		rrq, ok := packet.(tftp.ReadRequest)
@@ -68,252 +362,296 @@ func handlePacket(ctx context.Context, remote *net.UDPAddr, packet tftp.Packet,
 			return
 		}
 		filename := rrq.Filename
-		fullPath := filepath.Join(root, filename)
-
-		if _, err := os.Stat(fullPath); os.IsNotExist(err) {
-			log.Printf("file not found: %s", filename)
-			// TODO: send ERROR packet to client.
+		if err := s.checkAccess(tftp.RRQ, remote, filename); err != nil {
+			log.Printf("refusing to read %s from %v: %v", filename, remote, err)
+			sendError(local, remote, tftp.ErrorFromOS(err))
 			return
 		}
 
-		fileData, err := os.ReadFile(fullPath)
+		file, err := s.serveRead(&Request{Filename: filename, Mode: rrq.Mode, Options: rrq.Options, RemoteAddr: remote})
 		if err != nil {
-			log.Printf("failed to read file %s: %v", filename, err)
-			// TODO: send ERROR packet to client.
+			log.Printf("refusing to read %s from %v: %v", filename, remote, err)
+			sendError(local, remote, tftp.ErrorFromOS(err))
 			return
 		}
-
-		// Send DATA
-		handleRRQ(ctx, remote, fileData)
+		defer finishRead(file)
+
+		// Send DATA, streamed from the file a window at a time. The transfer
+		// is pinned to the size reported in tsize, even if the file grows.
+		size := readSize(file)
+		// Netascii goes out translated, to a length only known once the file
+		// has been read, so the size on disk is not acknowledged as tsize.
+		transferSize := size
+		if rrq.Mode == tftp.MODE_NETASCII {
+			transferSize = -1
+		}
+		n := newReadNegotiation(s.config(), transferSize)
+		accepted := n.negotiate(rrq.Options)
+		r := file
+		if size >= 0 {
+			r = io.LimitReader(file, size)
+		}
+		if rrq.Mode == tftp.MODE_NETASCII {
+			r = netascii.NewReader(r)
+		}
+		s.handleRRQ(ctx, local, remote, r, accepted, n.config)
 	case tftp.WRQ:
 		// SEND ACK
 		wrq, ok := packet.(protocol.WriteRequest)
 		if !ok {
-			log.Printf("failed to convert packet: %w\n", packet)
+			log.Printf("failed to convert packet: %v\n", packet)
 			return
 		}
-		go func() {
-			fullPath := filepath.Join(root, wrq.Filename)
-			file, err := os.Create(fullPath)
-			if err != nil {
-				fmt.Println("err: ", err)
-				log.Printf("failed to open file: %s\n", wrq.Filename)
-				// TODO: send ERROR packet.
-				return
+		if err := s.checkAccess(tftp.WRQ, remote, wrq.Filename); err != nil {
+			log.Printf("refusing to write %s from %v: %v", wrq.Filename, remote, err)
+			sendError(local, remote, tftp.ErrorFromOS(err))
+			return
+		}
+		release, err := s.reserveCreate(wrq.Filename)
+		if err != nil {
+			log.Printf("refusing to write %s from %v: %v", wrq.Filename, remote, err)
+			sendError(local, remote, tftp.ErrorFromOS(err))
+			return
+		}
+		defer release()
+		if err := s.checkWrite(wrq.Filename); err != nil {
+			log.Printf("refusing to write %s from %v: %v", wrq.Filename, remote, err)
+			sendError(local, remote, tftp.ErrorFromOS(err))
+			return
+		}
+
+		n := newWriteNegotiation(s.config())
+		accepted := n.negotiate(wrq.Options)
+		if !s.hasRoomFor(n.uploadSize) {
+			log.Printf("rejecting upload of %s: %s exceeds the allowed size", wrq.Filename, humanize.Bytes(uint64(n.uploadSize)))
+			sendError(local, remote, errUploadTooLarge)
+			return
+		}
+
+		file, err := s.serveWrite(&Request{Filename: wrq.Filename, Mode: wrq.Mode, Options: wrq.Options, RemoteAddr: remote})
+		if err != nil {
+			log.Printf("failed to open file %s: %v\n", wrq.Filename, err)
+			sendError(local, remote, tftp.ErrorFromOS(err))
+			return
+		}
+
+		// A client that declared no tsize is held to the limit as the data arrives.
+		var w io.Writer = file
+		if s.MaxUploadSize > 0 {
+			w = &limitedWriter{w: file, remaining: s.MaxUploadSize}
+		}
+		var decoder io.WriteCloser
+		if wrq.Mode == tftp.MODE_NETASCII {
+			decoder = netascii.NewWriter(w)
+			w = decoder
+		}
+
+		// The upload is stored before the final ACK, so a client is only
+		// told it succeeded once it has.
+		committed := false
+		commit := func() error {
+			if decoder != nil {
+				// Flush a trailing CR held back by the decoder.
+				if err := decoder.Close(); err != nil {
+					return err
+				}
 			}
-			defer file.Close()
-			handleWRQ(ctx, remote, file)
-		}()
-	default:
-		// ACK, DATA, and ERROR
-		// should never be sent to the server listening at port 69.
-		// Well, ERROR packet can be recevied iff the sender received two
+			committed = true
+			return finishWrite(file, false)
+		}
+
+		// A failed commit cleans up after itself and is logged by handleWRQ.
+		if err := s.handleWRQ(ctx, local, remote, w, accepted, n.config, commit); err != nil && !committed {
+			if abortErr := finishWrite(file, true); abortErr != nil {
+				log.Printf("failed to discard upload of %s: %v", wrq.Filename, abortErr)
+			}
+		}
+	case tftp.ERROR:
+		// An ERROR packet can be received iff the sender received two
 		// responses with different TIDs, and the sender rejected one while maintaining the other.
-		// ...on the todo list.
+		// Errors are never answered.
+	default:
+		// ACK, DATA, and OACK should never be sent to the server listening at port 69.
+		s.rejectMalformed(local, remote, REASON_NOT_A_REQUEST, fmt.Errorf("unexpected opcode %d", packet.OpCode()))
 	}
 }
 
-func handleWRQ(ctx context.Context, remote *net.UDPAddr, file *os.File) {
-	// TID := utils.GenerateTID()
-	log.Printf("Remote address: %v (IP: %v, Port: %d)", remote, remote.IP, remote.Port)
-
-	newConn, err := net.DialUDP("udp", nil, remote)
-	// newConn, err := net.ListenUDP("udp", &net.UDPAddr{Port: TID})
+// sendError reports a failure to remote from a fresh socket, used when no
+// session socket exists. ERROR packets are neither acknowledged nor
+// retransmitted, so this is best effort.
+func sendError(local, remote *net.UDPAddr, packet tftp.Error) {
+	// No reply is expected, so the port need not come from PortRange,
+	// which may be exhausted.
+	conn, err := net.ListenUDP("udp", bindAddr(local))
 	if err != nil {
-		log.Printf("failed to open conn: %v", err)
+		log.Printf("failed to open conn for ERROR to %v: %v", remote, err)
 		return
 	}
-	defer newConn.Close()
-
-	blockNum := uint16(0)
-	maxRetries := 5
-	timeout := 5 * time.Second
-	var fileData []byte
-	var dataPacket protocol.Data
-
-	for {
-		select {
-		case <-ctx.Done():
-			log.Printf("context cancelled: %v", ctx.Err())
-			return
-		default:
-			// Continue with transfer.
-		}
+	defer conn.Close()
 
-		retries := 0
-		for retries < maxRetries {
-			ackPacket := protocol.Ack{BlockNumber: blockNum}
-			_, err := newConn.Write(ackPacket.ToBinary())
-			if err != nil {
-				retries++
-				continue
-			}
+	if _, err := conn.WriteToUDP(packet.ToBinary(), remote); err != nil {
+		log.Printf("failed to send ERROR to %v: %v", remote, err)
+	}
+}
 
-			buffer := make([]byte, 1024)
-			newConn.SetReadDeadline(time.Now().Add(timeout))
-			n, err := newConn.Read(buffer)
+// serveRead asks the read handler for the file an RRQ names.
+func (s *Server) serveRead(req *Request) (io.Reader, error) {
+	if s.reads == nil {
+		return nil, tftp.NewError(tftp.ERR_FILE_NOT_FOUND)
+	}
 
-			if err != nil {
-				retries++
-				continue
-			}
+	return s.reads.ServeRead(req)
+}
 
-			packet, err := protocol.Parse(buffer[:n])
-			if err != nil {
-				log.Printf("failed to parse packet: %w", err)
-				return
-			}
+// serveWrite asks the write handler where to store the upload a WRQ names.
+func (s *Server) serveWrite(req *Request) (io.Writer, error) {
+	if s.writes == nil {
+		return nil, errReadOnly
+	}
 
-			// Check if it's an ERROR packet.
-			if packet.OpCode() == protocol.ERROR {
-				errorPacket, ok := packet.(protocol.Error)
-				if ok {
-					log.Printf("server error %d: %s", errorPacket.ErrorCode, errorPacket.ErrorMsg)
-				} else {
-					log.Println("received error packet from server")
-				}
-				return
-			}
+	return s.writes.ServeWrite(req)
+}
 
-			if packet.OpCode() != protocol.DATA {
-				retries++
-				continue
-			}
+// config returns the transfer parameters a session starts from before negotiation.
+func (s *Server) config() transfer.Config {
+	config := transfer.DefaultConfig()
+	config.Rollover = s.Rollover
 
-			data, ok := packet.(protocol.Data)
-			if !ok {
-				retries++
-				continue
-			}
+	return config
+}
 
-			expectedDataBlock := blockNum + 1
+// hasRoomFor reports whether an upload of the declared size fits within
+// MaxUploadSize and the free space the backend reports. An unknown size
+// (-1) is only discovered as the data arrives, so it is let through.
+func (s *Server) hasRoomFor(size int64) bool {
+	if size < 0 {
+		return true
+	}
 
-			// Handle retry if the server didn't receive our last ACK.
-			if data.BlockNumber != expectedDataBlock {
-				if data.BlockNumber == blockNum {
-					ackPacket := protocol.Ack{BlockNumber: data.BlockNumber}
-					newConn.Write(ackPacket.ToBinary())
-				}
-				continue
-			}
+	if s.MaxUploadSize > 0 && size > s.MaxUploadSize {
+		return false
+	}
 
-			dataPacket = data
-			break
-		}
+	reporter, ok := s.writes.(spaceReporter)
+	if !ok {
+		return true
+	}
 
-		if retries >= maxRetries {
-			log.Printf("max retries reached for block %d", blockNum)
-			return
-		}
+	free := reporter.FreeSpace()
+	return free < 0 || size <= free
+}
 
-		fileData = append(fileData, dataPacket.Data...)
+// errUploadTooLarge rejects an upload past MaxUploadSize or the free disk space.
+var errUploadTooLarge = tftp.Error{ErrorCode: tftp.ERR_DISK_FULL, ErrorMsg: "upload exceeds the allowed size"}
 
-		n, err := file.Write(dataPacket.Data)
-		if n != len(dataPacket.Data) {
-			log.Println("wrote incomplete data into file, aborting")
-			return
-		}
-		if err != nil {
-			retries++
-			continue
-		}
+// limitedWriter fails writes that would take the total past remaining.
+type limitedWriter struct {
+	w         io.Writer
+	remaining int64
+}
 
-		blockNum++
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > l.remaining {
+		return 0, errUploadTooLarge
+	}
 
-		if n < client.TFTP_MAX_DATAGRAM_LENGTH {
-			fmt.Printf("Transfer complete: received %s\n", humanize.Bytes(uint64(len(fileData))))
-			return
-		}
+	n, err := l.w.Write(p)
+	l.remaining -= int64(n)
+	return n, err
+}
 
+// bindAddr returns the address to bind a reply socket to: the one the
+// request arrived on, so replies come from the same address family,
+// address and interface the client contacted, or any if unknown.
+func bindAddr(local *net.UDPAddr) *net.UDPAddr {
+	if local == nil || local.IP.IsUnspecified() {
+		return &net.UDPAddr{}
 	}
+
+	return &net.UDPAddr{IP: local.IP, Zone: local.Zone}
 }
 
-func handleRRQ(ctx context.Context, remote *net.UDPAddr, fileData []byte) {
-	TID := utils.GenerateTID()
-	newConn, err := net.DialUDP("udp", &net.UDPAddr{Port: TID}, remote)
-	// newConn, err := net.ListenUDP("udp", &net.UDPAddr{Port: TID})
+// errServerBusy answers a request when every port in PortRange is taken
+// by a running transfer. The client may try again later.
+var errServerBusy = tftp.Error{ErrorCode: tftp.ERR_NOT_DEFINED, ErrorMsg: "server busy, try again later"}
+
+// sessionConn opens the socket for a transfer with remote on a port from
+// PortRange, the server's TID, or answers remote with an ERROR if it
+// cannot. The socket is left unconnected so packets from other TIDs can be
+// answered with ERROR 5. The returned function closes it.
+func (s *Server) sessionConn(local, remote *net.UDPAddr) (*net.UDPConn, func(), error) {
+	conn, release, err := s.portAllocator().Listen(bindAddr(local), remote)
+	if errors.Is(err, utils.ErrPortsExhausted) {
+		log.Printf("refusing request from %v: %v", remote, err)
+		sendError(local, remote, errServerBusy)
+		return nil, nil, err
+	}
 	if err != nil {
 		log.Printf("failed to open conn: %v", err)
-		return
+		sendError(local, remote, tftp.ErrorFromOS(err))
+		return nil, nil, err
 	}
-	defer newConn.Close()
-
-	blockNum := uint16(1)
-	offset := 0
-	blockSize := client.TFTP_MAX_DATAGRAM_LENGTH
-	maxRetries := 5
-	timeout := 5 * time.Second
-
-	for {
-		select {
-		case <-ctx.Done():
-			log.Printf("context cancelled: %v", ctx.Err())
-			return
-		default:
-			// Continue with transfer.
-		}
 
-		end := offset + blockSize
-		if end > len(fileData) {
-			end = len(fileData)
-		}
-
-		dataPacket := tftp.Data{
-			BlockNumber: blockNum,
-			Data:        fileData[offset:end],
-		}
+	return conn, release, nil
+}
 
-		retries := 0
+// portAllocator returns the allocator for PortRange, created on first use.
+func (s *Server) portAllocator() *utils.PortAllocator {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-		for retries < maxRetries {
-			_, err := newConn.Write(dataPacket.ToBinary())
-			if err != nil {
-				log.Printf("failed to write: %v", err)
-				return
-			}
+	if s.ports == nil {
+		s.ports = utils.NewPortAllocator(s.PortRange)
+	}
+	return s.ports
+}
 
-			var buf [client.TFTP_MAX_DATAGRAM_LENGTH]byte
-			newConn.SetReadDeadline(time.Now().Add(timeout))
-			n, err := newConn.Read(buf[:])
+// handleWRQ receives an upload into file, calling commit before the final
+// ACK, and returns an error if it did not complete.
+func (s *Server) handleWRQ(ctx context.Context, local, remote *net.UDPAddr, file io.Writer, options map[string]string, config transfer.Config, commit func() error) error {
+	log.Printf("Remote address: %v (IP: %v, Port: %d)", remote, remote.IP, remote.Port)
 
-			if err != nil {
-				retries++
-				log.Printf("timeout waiting for ACK block %d (attempt %d/%d)",
-					blockNum, retries, maxRetries)
-				continue
-			}
+	newConn, release, err := s.sessionConn(local, remote)
+	if err != nil {
+		return err
+	}
+	defer release()
 
-			ackPacket, err := tftp.Parse(buf[:n])
-			if err != nil || ackPacket.OpCode() != tftp.ACK {
-				log.Printf("invalid packet received, expected ACK")
-				retries++
-				continue
-			}
+	// Accepted options are acknowledged with an OACK in place of ACK 0.
+	request := protocol.Ack{BlockNumber: 0}.ToBinary()
+	if len(options) > 0 {
+		request = protocol.OptionAck{Options: options}.ToBinary()
+	}
 
-			ack, ok := ackPacket.(tftp.Ack)
-			if !ok || ack.BlockNumber != blockNum {
-				log.Printf("wrong ACK block number, expected %d got %d",
-					blockNum, ack.BlockNumber)
-				retries++
-				continue
-			}
+	receiver := transfer.Receiver{Config: config, Request: request, OnComplete: commit}
+	stats, err := receiver.Receive(ctx, transfer.NewPeerConn(newConn, remote), file)
+	if err != nil {
+		log.Printf("write transfer from %v failed after %d blocks: %v", remote, stats.Blocks, err)
+		return err
+	}
 
-			// ACK received successfully.
-			break
-		}
+	fmt.Printf("Transfer complete: received %s\n", humanize.Bytes(uint64(stats.Bytes)))
+	return nil
+}
 
-		if retries >= maxRetries {
-			log.Printf("max retries reached for block %d, aborting", blockNum)
-			return
-		}
+func (s *Server) handleRRQ(ctx context.Context, local, remote *net.UDPAddr, r io.Reader, options map[string]string, config transfer.Config) {
+	newConn, release, err := s.sessionConn(local, remote)
+	if err != nil {
+		return
+	}
+	defer release()
 
-		// Check if transfer complete (last block < client.TFTP_MAX_DATAGRAM_LENGTH bytes).
-		if end-offset < blockSize {
-			log.Printf("transfer complete")
-			return
-		}
+	sender := transfer.Sender{Config: config}
+	// Accepted options are sent in an OACK, which the client confirms with ACK 0.
+	if len(options) > 0 {
+		sender.Request = tftp.OptionAck{Options: options}.ToBinary()
+	}
 
-		blockNum++
-		offset = end
+	stats, err := sender.Send(ctx, transfer.NewPeerConn(newConn, remote), r)
+	if err != nil {
+		log.Printf("read transfer to %v failed after %d blocks: %v", remote, stats.Blocks, err)
+		return
 	}
+
+	log.Printf("transfer complete")
 }
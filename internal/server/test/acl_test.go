@@ -0,0 +1,116 @@
+package test
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	tftp "tftp/internal/protocol/parse"
+	"tftp/internal/server"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testACL = `
+# Lab hosts may fetch anything, but only switches may upload, and only backups.
+allow read  10.0.0.0/8
+allow write 10.1.0.0/16     backups/   # switch management network
+deny  all   2001:db8:bad::/48
+allow read  2001:db8::/32
+deny  all   any
+`
+
+func TestParseACL(t *testing.T) {
+	acl, err := server.ParseACL(strings.NewReader(testACL))
+	require.NoError(t, err)
+
+	tests := []struct {
+		opCode   tftp.OpCode
+		ip       string
+		filename string
+		allowed  bool
+	}{
+		{tftp.RRQ, "10.9.9.9", "pxelinux.0", true},
+		{tftp.WRQ, "10.9.9.9", "backups/sw1.cfg", false},
+		{tftp.WRQ, "10.1.2.3", "backups/sw1.cfg", true},
+		{tftp.WRQ, "10.1.2.3", "./backups//sw1.cfg", true},
+		{tftp.WRQ, "10.1.2.3", "backups-old/sw1.cfg", false},
+		{tftp.WRQ, "10.1.2.3", "pxelinux.0", false},
+		{tftp.RRQ, "::ffff:10.9.9.9", "pxelinux.0", true},
+		{tftp.RRQ, "2001:db8:1::1", "pxelinux.0", true},
+		{tftp.RRQ, "2001:db8:bad::1", "pxelinux.0", false},
+		{tftp.RRQ, "192.168.1.1", "pxelinux.0", false},
+		{tftp.RRQ, "fe80::1", "pxelinux.0", false},
+	}
+
+	for _, test := range tests {
+		rule := acl.Match(test.opCode, net.ParseIP(test.ip), test.filename)
+		if assert.NotNil(t, rule, "%s %s", test.ip, test.filename) {
+			assert.Equal(t, test.allowed, rule.Allow, "%s %s matched %v", test.ip, test.filename, rule)
+		}
+	}
+}
+
+func TestParseACLErrors(t *testing.T) {
+	for _, text := range []string{
+		"permit read any",
+		"allow delete any",
+		"allow read 10.0.0.0/33",
+		"allow read not-an-address",
+		"allow read",
+		"allow read any backups extra",
+	} {
+		_, err := server.ParseACL(strings.NewReader("# header\n" + text))
+		assert.ErrorContains(t, err, "line 2", text)
+	}
+}
+
+func TestACLDeniesRequests(t *testing.T) {
+	acl, err := server.ParseACL(strings.NewReader(`
+deny  read  any  private
+allow all   any
+`))
+	require.NoError(t, err)
+
+	backend := server.NewMemoryBackend()
+	backend.Put("public", []byte("public"))
+	backend.Put("private/key", []byte("private"))
+
+	addrs := []*net.UDPAddr{serveACL(t, "127.0.0.1", backend, acl)}
+	if addr := serveACL(t, "::1", backend, acl); addr != nil {
+		addrs = append(addrs, addr)
+	}
+
+	for _, addr := range addrs {
+		reply := request(t, addr, tftp.ReadRequest{Filename: "public", Mode: tftp.MODE_OCTET}.ToBinary())
+		assert.Equal(t, tftp.Data{BlockNumber: 1, Data: []byte("public")}, reply, addr)
+
+		reply = request(t, addr, tftp.ReadRequest{Filename: "private/key", Mode: tftp.MODE_OCTET}.ToBinary())
+		assert.Equal(t, tftp.NewError(tftp.ERR_ACCESS_VIOLATION), reply, addr)
+	}
+}
+
+// serveACL runs a server with acl on ip, returning nil if ip is unavailable.
+func serveACL(t *testing.T, ip string, backend server.Backend, acl *server.ACL) *net.UDPAddr {
+	t.Helper()
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP(ip)})
+	if err != nil {
+		t.Logf("skipping %s: %v", ip, err)
+		return nil
+	}
+
+	srv := server.New(nil, backend)
+	srv.ACL = acl
+	go srv.Serve(context.Background(), conn)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		srv.Shutdown(ctx)
+	})
+
+	return conn.LocalAddr().(*net.UDPAddr)
+}
@@ -0,0 +1,163 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"tftp/internal/client"
+	tftp "tftp/internal/protocol/parse"
+	"tftp/internal/server"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAbortedUploadKeepsExistingFile(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(root, "backup.cfg"), []byte("last good backup"), 0o644))
+	_, addr := startServer(t, root)
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.WriteToUDP(tftp.WriteRequest{Filename: "backup.cfg", Mode: tftp.MODE_OCTET}.ToBinary(), addr)
+	require.NoError(t, err)
+
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, session, err := conn.ReadFromUDP(buf)
+	require.NoError(t, err)
+	reply, err := tftp.Parse(buf[:n])
+	require.NoError(t, err)
+	require.Equal(t, tftp.Ack{BlockNumber: 0}, reply)
+
+	// Part of the new file arrives, then the client gives up.
+	_, err = conn.WriteToUDP(tftp.Data{BlockNumber: 1, Data: make([]byte, 512)}.ToBinary(), session)
+	require.NoError(t, err)
+	_, err = conn.WriteToUDP(tftp.NewError(tftp.ERR_NOT_DEFINED).ToBinary(), session)
+	require.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		return stagedUploads(t, root) == 0
+	}, 2*time.Second, 10*time.Millisecond, "temporary file was not removed")
+	contents, err := os.ReadFile(filepath.Join(root, "backup.cfg"))
+	require.NoError(t, err)
+	assert.Equal(t, "last good backup", string(contents))
+}
+
+func TestUploadReplacesFile(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(root, "backup.cfg"), []byte("old"), 0o644))
+	_, addr := startServer(t, root)
+
+	local := filepath.Join(t.TempDir(), "backup.cfg")
+	require.NoError(t, os.WriteFile(local, []byte("new backup"), 0o644))
+	_, err := client.New(addr.String()).Put(context.Background(), "backup.cfg", local)
+	require.NoError(t, err)
+
+	contents, err := os.ReadFile(filepath.Join(root, "backup.cfg"))
+	require.NoError(t, err)
+	assert.Equal(t, "new backup", string(contents))
+	assert.Zero(t, stagedUploads(t, root))
+}
+
+func TestStagedUploadsAreHidden(t *testing.T) {
+	root := t.TempDir()
+	staging := filepath.Join(root, server.STAGING_DIR)
+	require.NoError(t, os.Mkdir(staging, 0o700))
+	require.NoError(t, os.WriteFile(filepath.Join(staging, "left.1.tmp"), []byte("partial"), 0o644))
+	_, addr := startServer(t, root)
+
+	// Whatever a crashed server left behind is discarded at startup.
+	assert.Zero(t, stagedUploads(t, root))
+
+	for _, filename := range []string{server.STAGING_DIR + "/left.1.tmp", "./.TFTP-STAGING/left.1.tmp", server.STAGING_DIR} {
+		reply := request(t, addr, tftp.ReadRequest{Filename: filename, Mode: tftp.MODE_OCTET}.ToBinary())
+		if errPacket, ok := reply.(tftp.Error); assert.True(t, ok, "%s: got %v", filename, reply) {
+			assert.Equal(t, tftp.ERR_ACCESS_VIOLATION, errPacket.ErrorCode, filename)
+		}
+		assertWriteReply(t, addr, filename, tftp.ERR_ACCESS_VIOLATION)
+	}
+	assertWriteReply(t, addr, "missing/upload", tftp.ERR_FILE_NOT_FOUND)
+}
+
+// stagedUploads counts the uploads in progress under root.
+func stagedUploads(t *testing.T, root string) int {
+	t.Helper()
+
+	entries, err := os.ReadDir(filepath.Join(root, server.STAGING_DIR))
+	if os.IsNotExist(err) {
+		return 0
+	}
+	require.NoError(t, err)
+	return len(entries)
+}
+
+func TestUploadKeepsFileMode(t *testing.T) {
+	root := t.TempDir()
+	target := filepath.Join(root, "secrets.cfg")
+	require.NoError(t, os.WriteFile(target, []byte("old"), 0o600))
+	require.NoError(t, os.Chmod(target, 0o600))
+	_, addr := startServer(t, root)
+
+	local := filepath.Join(t.TempDir(), "secrets.cfg")
+	require.NoError(t, os.WriteFile(local, []byte("new secrets"), 0o644))
+	_, err := client.New(addr.String()).Put(context.Background(), "secrets.cfg", local)
+	require.NoError(t, err)
+
+	contents, err := os.ReadFile(target)
+	require.NoError(t, err)
+	assert.Equal(t, "new secrets", string(contents))
+	info, err := os.Stat(target)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+}
+
+// failingCommit accepts an upload but cannot store it.
+type failingCommit struct{ bytes.Buffer }
+
+func (*failingCommit) Commit() error { return syscall.ENOSPC }
+
+func TestFailedCommitIsReported(t *testing.T) {
+	writes := server.WriteHandlerFunc(func(req *server.Request) (io.Writer, error) {
+		return &failingCommit{}, nil
+	})
+	conn := listenLoopback(t)
+	srv := server.NewWithHandlers(nil, nil, writes)
+	go srv.Serve(context.Background(), conn)
+	t.Cleanup(func() { srv.Shutdown(context.Background()) })
+
+	local := filepath.Join(t.TempDir(), "upload")
+	require.NoError(t, os.WriteFile(local, []byte("never stored"), 0o644))
+	_, err := client.New(conn.LocalAddr().String()).Put(context.Background(), "upload", local)
+	var remoteErr *client.TFTPError
+	if assert.ErrorAs(t, err, &remoteErr) {
+		assert.Equal(t, tftp.ERR_DISK_FULL, remoteErr.Code)
+	}
+}
+
+func TestFailedDownloadKeepsLocalFile(t *testing.T) {
+	_, addr := startServer(t, t.TempDir())
+
+	dir := t.TempDir()
+	local := filepath.Join(dir, "config")
+	require.NoError(t, os.WriteFile(local, []byte("keep me"), 0o600))
+
+	_, err := client.New(addr.String()).Get(context.Background(), "missing", local)
+	assert.Error(t, err)
+
+	contents, err := os.ReadFile(local)
+	require.NoError(t, err)
+	assert.Equal(t, "keep me", string(contents))
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
@@ -0,0 +1,68 @@
+package test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"tftp/internal/client"
+	tftp "tftp/internal/protocol/parse"
+	"tftp/internal/server"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryBackendRoundTrip(t *testing.T) {
+	backend := server.NewMemoryBackend()
+	backend.Put("hello.txt", []byte("hello"))
+	_, addr := startBackend(t, backend)
+
+	dir := t.TempDir()
+	cli := client.New(addr.String())
+	cli.BlockSize = 8
+	_, err := cli.Get(context.Background(), "hello.txt", filepath.Join(dir, "hello.txt"))
+	require.NoError(t, err)
+	got, err := os.ReadFile(filepath.Join(dir, "hello.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(got))
+
+	upload := filepath.Join(dir, "upload.txt")
+	require.NoError(t, os.WriteFile(upload, []byte("uploaded through the backend"), 0o644))
+	_, err = cli.Put(context.Background(), "upload.txt", upload)
+	require.NoError(t, err)
+	stored, ok := backend.Get("upload.txt")
+	assert.True(t, ok)
+	assert.Equal(t, "uploaded through the backend", string(stored))
+}
+
+func TestFSBackendIsReadOnly(t *testing.T) {
+	fsys := fstest.MapFS{
+		"boot/pxelinux.0": {Data: []byte("pxe")},
+	}
+	_, addr := startBackend(t, server.NewFSBackend(fsys))
+
+	reply := request(t, addr, tftp.ReadRequest{Filename: "boot/pxelinux.0", Mode: tftp.MODE_OCTET}.ToBinary())
+	assert.Equal(t, tftp.Data{BlockNumber: 1, Data: []byte("pxe")}, reply)
+
+	for filename, code := range map[string]uint16{
+		"missing":          tftp.ERR_FILE_NOT_FOUND,
+		"boot":             tftp.ERR_ACCESS_VIOLATION,
+		"../boot":          tftp.ERR_ACCESS_VIOLATION,
+		"/boot/pxelinux.0": tftp.ERR_ACCESS_VIOLATION,
+	} {
+		reply := request(t, addr, tftp.ReadRequest{Filename: filename, Mode: tftp.MODE_OCTET}.ToBinary())
+		errorPacket, ok := reply.(tftp.Error)
+		if assert.True(t, ok, "%s: expected ERROR, got %#v", filename, reply) {
+			assert.Equal(t, code, errorPacket.ErrorCode, filename)
+		}
+	}
+
+	reply = request(t, addr, tftp.WriteRequest{Filename: "boot/pxelinux.0", Mode: tftp.MODE_OCTET}.ToBinary())
+	errorPacket, ok := reply.(tftp.Error)
+	if assert.True(t, ok, "expected ERROR, got %#v", reply) {
+		assert.Equal(t, tftp.ERR_ACCESS_VIOLATION, errorPacket.ErrorCode)
+	}
+}
@@ -0,0 +1,96 @@
+package test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	tftp "tftp/internal/protocol/parse"
+	"tftp/internal/server"
+
+	"github.com/stretchr/testify/require"
+)
+
+// startServer runs a server for root on a free localhost port,
+// shutting it down when the test ends.
+func startServer(t *testing.T, root string) (*server.Server, *net.UDPAddr) {
+	t.Helper()
+
+	return startBackend(t, localBackend(t, root))
+}
+
+// startBackend runs a server for backend on a free localhost port,
+// shutting it down when the test ends. configure, if given, sets up the
+// server before it starts.
+func startBackend(t *testing.T, backend server.Backend, configure ...func(*server.Server)) (*server.Server, *net.UDPAddr) {
+	t.Helper()
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+
+	return startBackendOn(t, conn, backend, configure...)
+}
+
+// startBackendOn runs a server for backend on conn, shutting it down when
+// the test ends. configure, if given, sets up the server before it starts.
+func startBackendOn(t *testing.T, conn *net.UDPConn, backend server.Backend, configure ...func(*server.Server)) (*server.Server, *net.UDPAddr) {
+	t.Helper()
+
+	srv := server.New(nil, backend)
+	for _, f := range configure {
+		f(srv)
+	}
+	go srv.Serve(context.Background(), conn)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		srv.Shutdown(ctx)
+	})
+
+	return srv, conn.LocalAddr().(*net.UDPAddr)
+}
+
+// localBackend opens root for serving, closing it when the test ends.
+func localBackend(t *testing.T, root string) *server.LocalBackend {
+	t.Helper()
+
+	backend, err := server.NewLocalBackend(root)
+	require.NoError(t, err)
+	t.Cleanup(func() { backend.Close() })
+
+	return backend
+}
+
+// request sends a single packet to the server and returns its first reply.
+func request(t *testing.T, addr *net.UDPAddr, packet []byte) tftp.Packet {
+	t.Helper()
+
+	reply, ok := tryRequest(t, addr, packet, 2*time.Second)
+	require.True(t, ok, "no reply from server")
+	return reply
+}
+
+// tryRequest sends a single packet to the server and waits up to timeout for a reply.
+func tryRequest(t *testing.T, addr *net.UDPAddr, packet []byte, timeout time.Duration) (tftp.Packet, bool) {
+	t.Helper()
+
+	// Send from the server's loopback address, which may be IPv4 or IPv6.
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: addr.IP})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.WriteToUDP(packet, addr)
+	require.NoError(t, err)
+
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	n, _, err := conn.ReadFromUDP(buf)
+	if err != nil {
+		return nil, false
+	}
+
+	reply, err := tftp.Parse(buf[:n])
+	require.NoError(t, err)
+	return reply, true
+}
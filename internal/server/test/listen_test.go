@@ -0,0 +1,68 @@
+package test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	tftp "tftp/internal/protocol/parse"
+	"tftp/internal/server"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListenOnMultipleAddresses(t *testing.T) {
+	addrs := []string{"127.0.0.1:0"}
+	if conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv6loopback}); err == nil {
+		conn.Close()
+		addrs = append(addrs, "[::1]:0")
+	} else {
+		t.Logf("IPv6 loopback unavailable: %v", err)
+	}
+
+	backend := server.NewMemoryBackend()
+	backend.Put("file", []byte("contents"))
+	srv := server.New(addrs, backend)
+	served := make(chan error, 1)
+	go func() { served <- srv.ListenAndServe() }()
+
+	require.Eventually(t, func() bool { return len(srv.Addrs()) == len(addrs) }, time.Second, 10*time.Millisecond)
+	for _, addr := range srv.Addrs() {
+		listener := addr.(*net.UDPAddr)
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: listener.IP})
+		require.NoError(t, err)
+		defer conn.Close()
+
+		_, err = conn.WriteToUDP(tftp.ReadRequest{Filename: "file", Mode: tftp.MODE_OCTET}.ToBinary(), listener)
+		require.NoError(t, err)
+
+		buf := make([]byte, 1024)
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, session, err := conn.ReadFromUDP(buf)
+		require.NoError(t, err, listener)
+		reply, err := tftp.Parse(buf[:n])
+		require.NoError(t, err)
+
+		assert.Equal(t, tftp.Data{BlockNumber: 1, Data: []byte("contents")}, reply, listener)
+		assert.True(t, session.IP.Equal(listener.IP), "reply to %v came from %v", listener, session)
+		assert.NotEqual(t, listener.Port, session.Port, "session must use a new TID")
+		conn.WriteToUDP(tftp.Ack{BlockNumber: 1}.ToBinary(), session)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, srv.Shutdown(ctx))
+	assert.ErrorIs(t, <-served, server.ErrServerClosed)
+}
+
+func TestListenFailureClosesOtherListeners(t *testing.T) {
+	taken, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer taken.Close()
+
+	srv := server.New([]string{"127.0.0.1:0", taken.LocalAddr().String()}, server.NewMemoryBackend())
+	assert.Error(t, srv.ListenAndServe())
+	assert.Empty(t, srv.Addrs())
+}
@@ -0,0 +1,87 @@
+package test
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	tftp "tftp/internal/protocol/parse"
+	"tftp/internal/server"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var garbage = []struct {
+	packet []byte
+	reason string
+}{
+	{[]byte{0x00}, server.REASON_TRUNCATED},
+	{[]byte{0x00, 0x01}, server.REASON_TRUNCATED},
+	{[]byte{0x00, 0x01, 'f', 'o', 'o'}, server.REASON_TRUNCATED},
+	{[]byte{0x00, 0x02, 'f', 0x00, 'o', 'c', 't'}, server.REASON_TRUNCATED},
+	{[]byte{0x00, 0x09, 'x'}, server.REASON_UNKNOWN_OPCODE},
+	{[]byte{0xff, 0xff}, server.REASON_UNKNOWN_OPCODE},
+	{[]byte("GET / HTTP/1.1\r\n\r\n"), server.REASON_UNKNOWN_OPCODE},
+	{append([]byte{0x00, 0x01, 'f', 0x00}, []byte("binary\x00")...), server.REASON_INVALID_MODE},
+	{append([]byte{0x00, 0x01, 'f', 0x00}, []byte("octet\x00blksize\x00")...), server.REASON_INVALID_OPTION},
+	{tftp.Data{BlockNumber: 1, Data: []byte("x")}.ToBinary(), server.REASON_NOT_A_REQUEST},
+	{tftp.Ack{BlockNumber: 1}.ToBinary(), server.REASON_NOT_A_REQUEST},
+}
+
+func TestMalformedPacketsGetIllegalOperation(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(root, "file"), []byte("still serving"), 0o644))
+	srv, addr := startServer(t, root)
+
+	expected := map[string]uint64{}
+	for _, tc := range garbage {
+		reply := request(t, addr, tc.packet)
+		assert.Equal(t, tftp.NewError(tftp.ERR_ILLEGAL_OPERATION), reply, "%q", tc.packet)
+		expected[tc.reason]++
+	}
+
+	// An empty datagram is counted too.
+	conn, err := net.DialUDP("udp", nil, addr)
+	require.NoError(t, err)
+	conn.Write(nil)
+	conn.Close()
+	expected[server.REASON_EMPTY]++
+
+	reply := request(t, addr, tftp.ReadRequest{Filename: "file", Mode: tftp.MODE_OCTET}.ToBinary())
+	assert.Equal(t, tftp.Data{BlockNumber: 1, Data: []byte("still serving")}, reply)
+	assert.Equal(t, expected, srv.MalformedPackets())
+}
+
+func TestMalformedRepliesAreRateLimited(t *testing.T) {
+	srv, addr := startServer(t, t.TempDir())
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	const sent = 200
+	for i := 0; i < sent; i++ {
+		conn.WriteToUDP([]byte{0xff, 0xff, byte(i)}, addr)
+	}
+
+	replies := 0
+	buf := make([]byte, 1024)
+	for {
+		conn.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+		if _, _, err := conn.ReadFromUDP(buf); err != nil {
+			break
+		}
+		replies++
+	}
+
+	assert.Greater(t, replies, 0)
+	assert.Less(t, replies, sent/2)
+	assert.Equal(t, uint64(sent), srv.MalformedPackets()[server.REASON_UNKNOWN_OPCODE])
+
+	// The server keeps answering valid requests.
+	reply := request(t, addr, tftp.ReadRequest{Filename: "missing", Mode: tftp.MODE_OCTET}.ToBinary())
+	assert.Equal(t, tftp.NewError(tftp.ERR_FILE_NOT_FOUND), reply)
+}
@@ -0,0 +1,123 @@
+package test
+
+import (
+	"bytes"
+	"testing"
+
+	tftp "tftp/internal/protocol/parse"
+	"tftp/internal/server"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func rrq(filename string, options map[string]string) []byte {
+	return tftp.ReadRequest{Filename: filename, Mode: tftp.MODE_OCTET, Options: options}.ToBinary()
+}
+
+func TestBlockSizeNegotiation(t *testing.T) {
+	backend := server.NewMemoryBackend()
+	backend.Put("file", bytes.Repeat([]byte("x"), 1500))
+	_, addr := startBackend(t, backend)
+
+	for _, test := range []struct {
+		name      string
+		requested string
+		acked     string
+	}{
+		{"echoed", "1024", "1024"},
+		{"minimum", "8", "8"},
+		{"clamped to the maximum", "100000", "65464"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			reply := request(t, addr, rrq("file", map[string]string{tftp.OPTION_BLKSIZE: test.requested}))
+			assert.Equal(t, tftp.OptionAck{Options: map[string]string{tftp.OPTION_BLKSIZE: test.acked}}, reply)
+		})
+	}
+
+	for _, refused := range []string{"7", "0", "-1", "big"} {
+		t.Run("refused "+refused, func(t *testing.T) {
+			// A refused option is left out, so without others there is no OACK
+			// and the transfer starts at once with the default block size.
+			reply := request(t, addr, rrq("file", map[string]string{tftp.OPTION_BLKSIZE: refused}))
+			if data, ok := reply.(tftp.Data); assert.True(t, ok, "got %v", reply) {
+				assert.Equal(t, uint16(1), data.BlockNumber)
+				assert.Len(t, data.Data, tftp.DEFAULT_BLOCK_SIZE)
+			}
+		})
+	}
+}
+
+func TestNegotiatedBlockSizeIsUsed(t *testing.T) {
+	backend := server.NewMemoryBackend()
+	data := bytes.Repeat([]byte("y"), 1500)
+	backend.Put("file", data)
+	_, addr := startBackend(t, backend)
+
+	client := listenLoopback(t)
+	_, err := client.WriteToUDP(rrq("file", map[string]string{tftp.OPTION_BLKSIZE: "1024"}), addr)
+	require.NoError(t, err)
+	reply, session := receive(t, client)
+	require.Equal(t, tftp.OptionAck{Options: map[string]string{tftp.OPTION_BLKSIZE: "1024"}}, reply)
+
+	_, err = client.WriteToUDP(tftp.Ack{BlockNumber: 0}.ToBinary(), session)
+	require.NoError(t, err)
+	reply, _ = receive(t, client)
+	assert.Equal(t, tftp.Data{BlockNumber: 1, Data: data[:1024]}, reply)
+
+	_, err = client.WriteToUDP(tftp.Ack{BlockNumber: 1}.ToBinary(), session)
+	require.NoError(t, err)
+	reply, _ = receive(t, client)
+	assert.Equal(t, tftp.Data{BlockNumber: 2, Data: data[1024:]}, reply)
+	client.WriteToUDP(tftp.Ack{BlockNumber: 2}.ToBinary(), session)
+}
+
+func TestTransferSizeNegotiation(t *testing.T) {
+	backend := server.NewMemoryBackend()
+	backend.Put("file", bytes.Repeat([]byte("x"), 1500))
+	_, addr := startBackend(t, backend, func(srv *server.Server) { srv.MaxUploadSize = 2000 })
+
+	t.Run("read reports the file size", func(t *testing.T) {
+		reply := request(t, addr, rrq("file", map[string]string{tftp.OPTION_TSIZE: "0"}))
+		assert.Equal(t, tftp.OptionAck{Options: map[string]string{tftp.OPTION_TSIZE: "1500"}}, reply)
+	})
+
+	t.Run("netascii read leaves the size unacknowledged", func(t *testing.T) {
+		reply := request(t, addr, tftp.ReadRequest{Filename: "file", Mode: tftp.MODE_NETASCII, Options: map[string]string{tftp.OPTION_TSIZE: "0"}}.ToBinary())
+		assert.IsType(t, tftp.Data{}, reply)
+	})
+
+	t.Run("write echoes the declared size", func(t *testing.T) {
+		reply := request(t, addr, tftp.WriteRequest{Filename: "upload", Mode: tftp.MODE_OCTET, Options: map[string]string{tftp.OPTION_TSIZE: "1234"}}.ToBinary())
+		assert.Equal(t, tftp.OptionAck{Options: map[string]string{tftp.OPTION_TSIZE: "1234"}}, reply)
+	})
+
+	t.Run("write larger than allowed is refused", func(t *testing.T) {
+		reply := request(t, addr, tftp.WriteRequest{Filename: "upload", Mode: tftp.MODE_OCTET, Options: map[string]string{tftp.OPTION_TSIZE: "2001"}}.ToBinary())
+		if errPacket, ok := reply.(tftp.Error); assert.True(t, ok, "got %v", reply) {
+			assert.Equal(t, tftp.ERR_DISK_FULL, errPacket.ErrorCode)
+		}
+	})
+
+	t.Run("negative size is refused", func(t *testing.T) {
+		reply := request(t, addr, rrq("file", map[string]string{tftp.OPTION_TSIZE: "-1"}))
+		assert.IsType(t, tftp.Data{}, reply)
+	})
+}
+
+func TestTimeoutNegotiation(t *testing.T) {
+	backend := server.NewMemoryBackend()
+	backend.Put("file", []byte("hello"))
+	_, addr := startBackend(t, backend)
+
+	for _, timeout := range []string{"1", "3", "255"} {
+		reply := request(t, addr, rrq("file", map[string]string{tftp.OPTION_TIMEOUT: timeout}))
+		assert.Equal(t, tftp.OptionAck{Options: map[string]string{tftp.OPTION_TIMEOUT: timeout}}, reply)
+	}
+
+	// An out-of-range timeout is left unacknowledged, while the other options still are.
+	for _, timeout := range []string{"0", "256", "-3", "soon"} {
+		reply := request(t, addr, rrq("file", map[string]string{tftp.OPTION_TIMEOUT: timeout, tftp.OPTION_TSIZE: "0"}))
+		assert.Equal(t, tftp.OptionAck{Options: map[string]string{tftp.OPTION_TSIZE: "5"}}, reply, "timeout %s", timeout)
+	}
+}
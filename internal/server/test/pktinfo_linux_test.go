@@ -0,0 +1,56 @@
+//go:build linux
+
+package test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	tftp "tftp/internal/protocol/parse"
+	"tftp/internal/server"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Linux routes all of 127.0.0.0/8 to the loopback interface, and the kernel
+// would pick 127.0.0.1 as the source of any reply to it, so requests to the
+// other addresses only get a reply from the same address if the server
+// reads their destination.
+func TestRepliesComeFromRequestedAddress(t *testing.T) {
+	backend := server.NewMemoryBackend()
+	backend.Put("file", []byte("contents"))
+
+	// A wildcard listener receives requests for every local address.
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{})
+	require.NoError(t, err)
+	_, listener := startBackendOn(t, conn, backend)
+
+	destinations := []net.IP{net.IPv4(127, 0, 0, 1), net.IPv4(127, 0, 0, 2), net.IPv4(127, 0, 0, 3)}
+	if probe, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv6loopback}); err == nil {
+		probe.Close()
+		destinations = append(destinations, net.IPv6loopback)
+	}
+
+	for _, destination := range destinations {
+		client, err := net.ListenUDP("udp", &net.UDPAddr{IP: destination})
+		require.NoError(t, err)
+		defer client.Close()
+
+		for _, packet := range [][]byte{
+			tftp.ReadRequest{Filename: "file", Mode: tftp.MODE_OCTET}.ToBinary(),
+			tftp.ReadRequest{Filename: "missing", Mode: tftp.MODE_OCTET}.ToBinary(),
+			tftp.WriteRequest{Filename: "upload", Mode: tftp.MODE_OCTET}.ToBinary(),
+		} {
+			_, err = client.WriteToUDP(packet, &net.UDPAddr{IP: destination, Port: listener.Port})
+			require.NoError(t, err)
+
+			buf := make([]byte, 1024)
+			client.SetReadDeadline(time.Now().Add(2 * time.Second))
+			_, source, err := client.ReadFromUDP(buf)
+			require.NoError(t, err, destination)
+			assert.True(t, source.IP.Equal(destination), "request to %v answered from %v", destination, source)
+		}
+	}
+}
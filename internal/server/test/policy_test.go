@@ -0,0 +1,163 @@
+package test
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	tftp "tftp/internal/protocol/parse"
+	"tftp/internal/server"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWritePolicies(t *testing.T) {
+	tests := []struct {
+		policy   server.WritePolicy
+		existing uint16 // Reply to a WRQ for a file that exists.
+		missing  uint16 // Reply to a WRQ for a new file.
+	}{
+		{server.WRITE_ALLOW_ALL, 0, 0},
+		{server.WRITE_READ_ONLY, tftp.ERR_ACCESS_VIOLATION, tftp.ERR_ACCESS_VIOLATION},
+		{server.WRITE_CREATE_ONLY, tftp.ERR_FILE_EXISTS, 0},
+		{server.WRITE_OVERWRITE_ONLY, 0, tftp.ERR_FILE_NOT_FOUND},
+	}
+
+	for _, test := range tests {
+		t.Run(test.policy.String(), func(t *testing.T) {
+			backend := server.NewMemoryBackend()
+			backend.Put("existing.cfg", []byte("backup"))
+			_, addr := startBackend(t, backend, func(srv *server.Server) {
+				srv.WritePolicy = test.policy
+			})
+
+			assertWriteReply(t, addr, "existing.cfg", test.existing)
+			assertWriteReply(t, addr, "missing.cfg", test.missing)
+		})
+	}
+}
+
+func TestWritePoliciesThroughServeMux(t *testing.T) {
+	backups := server.NewMemoryBackend()
+	backups.Put("backups/existing.cfg", []byte("backup"))
+	mux := server.NewServeMux()
+	mux.HandleWrite("backups/", server.BackendHandler{Backend: backups})
+	mux.HandleWrite("disk/", server.BackendHandler{Backend: localBackend(t, t.TempDir())})
+	mux.HandleWriteFunc("logs/", func(req *server.Request) (io.Writer, error) {
+		return io.Discard, nil
+	})
+
+	for _, test := range []struct {
+		policy   server.WritePolicy
+		existing uint16
+		missing  uint16
+	}{
+		{server.WRITE_CREATE_ONLY, tftp.ERR_FILE_EXISTS, 0},
+		{server.WRITE_OVERWRITE_ONLY, 0, tftp.ERR_FILE_NOT_FOUND},
+	} {
+		t.Run(test.policy.String(), func(t *testing.T) {
+			addr := startWrites(t, mux, test.policy)
+
+			assertWriteReply(t, addr, "backups/existing.cfg", test.existing)
+			assertWriteReply(t, addr, "backups/missing.cfg", test.missing)
+			// A handler that cannot tell is refused rather than let through.
+			assertWriteReply(t, addr, "logs/boot.log", tftp.ERR_ACCESS_VIOLATION)
+			assertWriteReply(t, addr, "unrouted.cfg", tftp.ERR_ACCESS_VIOLATION)
+		})
+	}
+
+	t.Run("free space", func(t *testing.T) {
+		if mux.FreeSpace() < 0 {
+			t.Skip("free space is not reported on this platform")
+		}
+
+		addr := startWrites(t, mux, server.WRITE_ALLOW_ALL)
+		reply := request(t, addr, tftp.WriteRequest{Filename: "disk/huge", Mode: tftp.MODE_OCTET, Options: map[string]string{tftp.OPTION_TSIZE: "9000000000000000000"}}.ToBinary())
+		if errPacket, ok := reply.(tftp.Error); assert.True(t, ok, "got %v", reply) {
+			assert.Equal(t, tftp.ERR_DISK_FULL, errPacket.ErrorCode)
+		}
+	})
+}
+
+// startWrites runs a server handing WRQs to writes under policy, shutting
+// it down when the test ends.
+func startWrites(t *testing.T, writes server.WriteHandler, policy server.WritePolicy) *net.UDPAddr {
+	t.Helper()
+
+	conn := listenLoopback(t)
+	srv := server.NewWithHandlers(nil, nil, writes)
+	srv.WritePolicy = policy
+	go srv.Serve(context.Background(), conn)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		srv.Shutdown(ctx)
+	})
+
+	return conn.LocalAddr().(*net.UDPAddr)
+}
+
+func TestWriteGlobs(t *testing.T) {
+	_, addr := startBackend(t, server.NewMemoryBackend(), func(srv *server.Server) {
+		srv.WriteAllow = []string{"backups/*.cfg", "logs/*"}
+		srv.WriteDeny = []string{"logs/secret*"}
+	})
+
+	assertWriteReply(t, addr, "backups/switch1.cfg", 0)
+	assertWriteReply(t, addr, "./backups/switch1.cfg", 0)
+	assertWriteReply(t, addr, "logs/boot.log", 0)
+	assertWriteReply(t, addr, "backups/switch1.bin", tftp.ERR_ACCESS_VIOLATION)
+	assertWriteReply(t, addr, "backups/nested/switch1.cfg", tftp.ERR_ACCESS_VIOLATION)
+	assertWriteReply(t, addr, "logs/secret.log", tftp.ERR_ACCESS_VIOLATION)
+	assertWriteReply(t, addr, "logs//secret.log", tftp.ERR_ACCESS_VIOLATION)
+}
+
+func TestCreateOnlyRacingUploads(t *testing.T) {
+	backend := server.NewMemoryBackend()
+	_, addr := startBackend(t, backend, func(srv *server.Server) {
+		srv.WritePolicy = server.WRITE_CREATE_ONLY
+	})
+
+	// The first WRQ is accepted, and its upload left open.
+	first := listenLoopback(t)
+	_, err := first.WriteToUDP(tftp.WriteRequest{Filename: "new.cfg", Mode: tftp.MODE_OCTET}.ToBinary(), addr)
+	require.NoError(t, err)
+	reply, session := receive(t, first)
+	require.Equal(t, tftp.Ack{BlockNumber: 0}, reply)
+
+	// A second WRQ for the same file, even spelled differently, must not
+	// also find it missing and replace the first upload when it commits.
+	assertWriteReply(t, addr, "new.cfg", tftp.ERR_FILE_EXISTS)
+	assertWriteReply(t, addr, "./new.cfg", tftp.ERR_FILE_EXISTS)
+	assertWriteReply(t, addr, "other.cfg", 0)
+
+	_, err = first.WriteToUDP(tftp.Data{BlockNumber: 1, Data: []byte("first")}.ToBinary(), session)
+	require.NoError(t, err)
+	reply, _ = receive(t, first)
+	require.Equal(t, tftp.Ack{BlockNumber: 1}, reply)
+	stored, ok := backend.Get("new.cfg")
+	assert.True(t, ok)
+	assert.Equal(t, "first", string(stored))
+
+	assertWriteReply(t, addr, "new.cfg", tftp.ERR_FILE_EXISTS)
+}
+
+// assertWriteReply sends a WRQ for filename and checks the server answers
+// with ACK 0, for code 0, or an ERROR with code.
+func assertWriteReply(t *testing.T, addr *net.UDPAddr, filename string, code uint16) {
+	t.Helper()
+
+	reply := request(t, addr, tftp.WriteRequest{Filename: filename, Mode: tftp.MODE_OCTET}.ToBinary())
+	if code == 0 {
+		assert.Equal(t, tftp.Ack{BlockNumber: 0}, reply, filename)
+		return
+	}
+
+	errorPacket, ok := reply.(tftp.Error)
+	if assert.True(t, ok, "%s: expected ERROR, got %#v", filename, reply) {
+		assert.Equal(t, code, errorPacket.ErrorCode, filename)
+	}
+}
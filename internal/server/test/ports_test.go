@@ -0,0 +1,58 @@
+package test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	tftp "tftp/internal/protocol/parse"
+	"tftp/internal/server"
+	"tftp/internal/utils"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBusyWhenPortRangeIsExhausted(t *testing.T) {
+	probe := listenLoopback(t)
+	port := probe.LocalAddr().(*net.UDPAddr).Port
+	probe.Close()
+
+	backend := server.NewMemoryBackend()
+	backend.Put("file", []byte("hello"))
+	_, addr := startBackend(t, backend, func(s *server.Server) {
+		s.PortRange = utils.PortRange{Min: port, Max: port}
+	})
+	rrq := tftp.ReadRequest{Filename: "file", Mode: tftp.MODE_OCTET}.ToBinary()
+
+	// The first transfer holds the only port until it is acknowledged.
+	client := listenLoopback(t)
+	_, err := client.WriteToUDP(rrq, addr)
+	require.NoError(t, err)
+	reply, session := receive(t, client)
+	require.Equal(t, tftp.Data{BlockNumber: 1, Data: []byte("hello")}, reply)
+	assert.Equal(t, port, session.Port)
+
+	reply = request(t, addr, rrq)
+	assert.Equal(t, tftp.Error{ErrorCode: tftp.ERR_NOT_DEFINED, ErrorMsg: "server busy, try again later"}, reply)
+
+	_, err = client.WriteToUDP(tftp.Ack{BlockNumber: 1}.ToBinary(), session)
+	require.NoError(t, err)
+
+	// Once the transfer ends its port serves the next request.
+	assert.Eventually(t, func() bool {
+		reply, ok := tryRequest(t, addr, rrq, 200*time.Millisecond)
+		data, isData := reply.(tftp.Data)
+		return ok && isData && string(data.Data) == "hello"
+	}, 2*time.Second, 50*time.Millisecond)
+}
+
+func TestServeRejectsInvalidPortRange(t *testing.T) {
+	srv := server.New(nil, server.NewMemoryBackend())
+	srv.PortRange = utils.PortRange{Min: 50099, Max: 50000}
+
+	conn := listenLoopback(t)
+	assert.Error(t, srv.Serve(context.Background(), conn))
+	assert.Error(t, srv.ListenAndServe())
+}
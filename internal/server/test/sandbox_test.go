@@ -0,0 +1,96 @@
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	tftp "tftp/internal/protocol/parse"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newSandbox lays out a root next to a secret that must stay unreachable:
+//
+//	base/secret
+//	base/outside/
+//	base/root/public
+//	base/root/inside -> public
+//	base/root/escape -> ../secret
+//	base/root/escapedir -> ../outside
+//	base/root/abs -> <absolute path of base/secret>
+func newSandbox(t *testing.T) (base, root string) {
+	t.Helper()
+
+	base = t.TempDir()
+	root = filepath.Join(base, "root")
+	require.NoError(t, os.Mkdir(root, 0o755))
+	require.NoError(t, os.Mkdir(filepath.Join(base, "outside"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(base, "secret"), []byte("secret"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "public"), []byte("public"), 0o644))
+	require.NoError(t, os.Symlink("public", filepath.Join(root, "inside")))
+	require.NoError(t, os.Symlink("../secret", filepath.Join(root, "escape")))
+	require.NoError(t, os.Symlink("../outside", filepath.Join(root, "escapedir")))
+	require.NoError(t, os.Symlink(filepath.Join(base, "secret"), filepath.Join(root, "abs")))
+
+	return base, root
+}
+
+var escapes = []string{
+	"../secret",
+	"../../etc/passwd",
+	"sub/../../secret",
+	"/etc/passwd",
+	"escape",
+	"abs",
+	"escapedir/secret",
+}
+
+func TestReadEscapesAreRefused(t *testing.T) {
+	_, root := newSandbox(t)
+	_, addr := startServer(t, root)
+
+	for _, filename := range escapes {
+		reply := request(t, addr, tftp.ReadRequest{Filename: filename, Mode: tftp.MODE_OCTET}.ToBinary())
+
+		errorPacket, ok := reply.(tftp.Error)
+		if assert.True(t, ok, "%s: expected ERROR, got %#v", filename, reply) {
+			assert.Equal(t, tftp.ERR_ACCESS_VIOLATION, errorPacket.ErrorCode, filename)
+		}
+	}
+}
+
+func TestWriteEscapesAreRefused(t *testing.T) {
+	base, root := newSandbox(t)
+	_, addr := startServer(t, root)
+
+	for _, filename := range []string{"../planted", "sub/../../planted", "/tmp/planted", "escapedir/planted", "escape"} {
+		reply := request(t, addr, tftp.WriteRequest{Filename: filename, Mode: tftp.MODE_OCTET}.ToBinary())
+
+		errorPacket, ok := reply.(tftp.Error)
+		if assert.True(t, ok, "%s: expected ERROR, got %#v", filename, reply) {
+			assert.Equal(t, tftp.ERR_ACCESS_VIOLATION, errorPacket.ErrorCode, filename)
+		}
+	}
+
+	assert.NoFileExists(t, filepath.Join(base, "planted"))
+	assert.NoFileExists(t, filepath.Join(base, "outside", "planted"))
+	secret, err := os.ReadFile(filepath.Join(base, "secret"))
+	require.NoError(t, err)
+	assert.Equal(t, "secret", string(secret))
+}
+
+func TestReadsInsideRootAreServed(t *testing.T) {
+	_, root := newSandbox(t)
+	_, addr := startServer(t, root)
+
+	for _, filename := range []string{"public", "inside", "./public"} {
+		reply := request(t, addr, tftp.ReadRequest{Filename: filename, Mode: tftp.MODE_OCTET}.ToBinary())
+
+		assert.Equal(t, tftp.Data{BlockNumber: 1, Data: []byte("public")}, reply, filename)
+	}
+
+	reply := request(t, addr, tftp.ReadRequest{Filename: "missing", Mode: tftp.MODE_OCTET}.ToBinary())
+	assert.Equal(t, tftp.NewError(tftp.ERR_FILE_NOT_FOUND), reply)
+}
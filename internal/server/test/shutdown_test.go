@@ -0,0 +1,138 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	tftp "tftp/internal/protocol/parse"
+	"tftp/internal/server"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startRead sends an RRQ for a multi-block file and returns the client
+// socket once block 1 has arrived, leaving the session waiting for ACK 1.
+func startRead(t *testing.T, addr *net.UDPAddr, filename string) (*net.UDPConn, *net.UDPAddr) {
+	t.Helper()
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	_, err = conn.WriteToUDP(tftp.ReadRequest{Filename: filename, Mode: tftp.MODE_OCTET}.ToBinary(), addr)
+	require.NoError(t, err)
+
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, session, err := conn.ReadFromUDP(buf)
+	require.NoError(t, err)
+
+	packet, err := tftp.Parse(buf[:n])
+	require.NoError(t, err)
+	require.Equal(t, tftp.OpCode(tftp.DATA), packet.OpCode())
+
+	return conn, session
+}
+
+func newBigFile(t *testing.T) string {
+	root := t.TempDir()
+	data := bytes.Repeat([]byte("x"), 4*tftp.DEFAULT_BLOCK_SIZE)
+	require.NoError(t, os.WriteFile(filepath.Join(root, "big"), data, 0o644))
+	return root
+}
+
+func TestShutdownDrainsSessions(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	addr := conn.LocalAddr().(*net.UDPAddr)
+
+	srv := server.New(nil, localBackend(t, newBigFile(t)))
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(context.Background(), conn) }()
+
+	client, session := startRead(t, addr, "big")
+	assert.Equal(t, 1, srv.ActiveSessions())
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- srv.Shutdown(context.Background()) }()
+
+	// The listener stops, but the running transfer is allowed to finish.
+	assert.ErrorIs(t, <-served, server.ErrServerClosed)
+	buf := make([]byte, 1024)
+	for block := uint16(1); ; block++ {
+		client.WriteToUDP(tftp.Ack{BlockNumber: block}.ToBinary(), session)
+		client.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, _, err := client.ReadFromUDP(buf)
+		require.NoError(t, err)
+		packet, err := tftp.Parse(buf[:n])
+		require.NoError(t, err)
+		data, ok := packet.(tftp.Data)
+		require.True(t, ok, "expected DATA, got %#v", packet)
+		if len(data.Data) < tftp.DEFAULT_BLOCK_SIZE {
+			client.WriteToUDP(tftp.Ack{BlockNumber: block + 1}.ToBinary(), session)
+			break
+		}
+	}
+
+	assert.NoError(t, <-shutdown)
+	assert.Equal(t, 0, srv.ActiveSessions())
+}
+
+func TestShutdownCancelsSessionsAtDeadline(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	addr := conn.LocalAddr().(*net.UDPAddr)
+
+	srv := server.New(nil, localBackend(t, newBigFile(t)))
+	go srv.Serve(context.Background(), conn)
+
+	client, _ := startRead(t, addr, "big")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	assert.ErrorIs(t, srv.Shutdown(ctx), context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 2*time.Second)
+	assert.Equal(t, 0, srv.ActiveSessions())
+
+	// The abandoned client is told the transfer is over.
+	buf := make([]byte, 1024)
+	for {
+		client.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, _, err := client.ReadFromUDP(buf)
+		require.NoError(t, err)
+		packet, err := tftp.Parse(buf[:n])
+		require.NoError(t, err)
+		if packet.OpCode() == tftp.ERROR {
+			break
+		}
+	}
+
+	// New requests are no longer answered.
+	_, ok := tryRequest(t, addr, tftp.ReadRequest{Filename: "big", Mode: tftp.MODE_OCTET}.ToBinary(), 200*time.Millisecond)
+	assert.False(t, ok)
+}
+
+func TestServeStopsWithContext(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+
+	srv := server.New(nil, localBackend(t, t.TempDir()))
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(ctx, conn) }()
+
+	cancel()
+	select {
+	case err := <-served:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(2 * time.Second):
+		t.Fatal("Serve did not return after its context was cancelled")
+	}
+}
@@ -0,0 +1,97 @@
+package test
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"tftp/internal/client"
+	tftp "tftp/internal/protocol/parse"
+	"tftp/internal/server"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var errReadAhead = errors.New("read more than one window past the last ACK")
+
+// pacedBackend wraps the readers of a Backend so they fail when the
+// server reads more than window bytes past what the client acknowledged.
+type pacedBackend struct {
+	server.Backend
+	acked  *atomic.Int64
+	window int64
+}
+
+func (b pacedBackend) Open(name string) (io.ReadCloser, int64, error) {
+	r, size, err := b.Backend.Open(name)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return &pacedReader{ReadCloser: r, acked: b.acked, window: b.window}, size, nil
+}
+
+type pacedReader struct {
+	io.ReadCloser
+	acked  *atomic.Int64
+	window int64
+	read   int64
+}
+
+func (r *pacedReader) Read(p []byte) (int, error) {
+	// Fail before reading, as io.ReadFull drops an error that comes with
+	// a full block.
+	if r.read >= r.acked.Load()+r.window {
+		return 0, errReadAhead
+	}
+
+	n, err := r.ReadCloser.Read(p)
+	r.read += int64(n)
+	return n, err
+}
+
+func TestReadsStreamOneWindowAtATime(t *testing.T) {
+	const blockSize, windowSize = 512, 4
+	data := make([]byte, 3000*blockSize+100)
+	rand.Read(data)
+
+	memory := server.NewMemoryBackend()
+	memory.Put("big", data)
+	root := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(root, "big"), data, 0o644))
+
+	for name, backend := range map[string]server.Backend{
+		"memory": memory,
+		"local":  localBackend(t, root),
+	} {
+		t.Run(name, func(t *testing.T) {
+			var acked atomic.Int64
+			_, addr := startBackend(t, pacedBackend{Backend: backend, acked: &acked, window: windowSize * blockSize})
+
+			cli := client.New(addr.String())
+			cli.BlockSize = blockSize
+			cli.WindowSize = windowSize
+			cli.Trace = func(sent bool, packet []byte) {
+				// Traced before it goes out, so the server never sees an
+				// ACK that acked does not yet count.
+				if sent && tftp.OpCode(binary.BigEndian.Uint16(packet)) == tftp.ACK {
+					acked.Store(int64(binary.BigEndian.Uint16(packet[2:])) * blockSize)
+				}
+			}
+
+			local := filepath.Join(t.TempDir(), "big")
+			_, err := cli.Get(context.Background(), "big", local)
+			require.NoError(t, err)
+			got, err := os.ReadFile(local)
+			require.NoError(t, err)
+			assert.Equal(t, data, got)
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package test
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	tftp "tftp/internal/protocol/parse"
+	"tftp/internal/server"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnknownTIDIsRejected(t *testing.T) {
+	backend := server.NewMemoryBackend()
+	data := bytes.Repeat([]byte("x"), 600)
+	backend.Put("file", data)
+	_, addr := startBackend(t, backend)
+
+	client := listenLoopback(t)
+	intruder := listenLoopback(t)
+
+	_, err := client.WriteToUDP(tftp.ReadRequest{Filename: "file", Mode: tftp.MODE_OCTET}.ToBinary(), addr)
+	require.NoError(t, err)
+	reply, session := receive(t, client)
+	require.Equal(t, tftp.Data{BlockNumber: 1, Data: data[:512]}, reply)
+	assert.NotEqual(t, addr.Port, session.Port, "the session must use its own TID")
+
+	// A stray ACK from another port is answered with ERROR 5...
+	_, err = intruder.WriteToUDP(tftp.Ack{BlockNumber: 1}.ToBinary(), session)
+	require.NoError(t, err)
+	reply, from := receive(t, intruder)
+	assert.Equal(t, tftp.NewError(tftp.ERR_UNKNOWN_TID), reply)
+	assert.Equal(t, session.Port, from.Port)
+
+	// ...and the transfer carries on with the real client.
+	_, err = client.WriteToUDP(tftp.Ack{BlockNumber: 1}.ToBinary(), session)
+	require.NoError(t, err)
+	reply, _ = receive(t, client)
+	assert.Equal(t, tftp.Data{BlockNumber: 2, Data: data[512:]}, reply)
+	client.WriteToUDP(tftp.Ack{BlockNumber: 2}.ToBinary(), session)
+}
+
+func listenLoopback(t *testing.T) *net.UDPConn {
+	t.Helper()
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return conn
+}
+
+// receive reads and parses one packet, failing the test after two seconds.
+func receive(t *testing.T, conn *net.UDPConn) (tftp.Packet, *net.UDPAddr) {
+	t.Helper()
+
+	buf := make([]byte, 65536)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, from, err := conn.ReadFromUDP(buf)
+	require.NoError(t, err)
+
+	packet, err := tftp.Parse(buf[:n])
+	require.NoError(t, err)
+	return packet, from
+}
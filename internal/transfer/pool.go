@@ -0,0 +1,23 @@
+package transfer
+
+import (
+	"sync"
+)
+
+// blockPool recycles block and packet buffers across sessions, so the
+// memory a transfer holds is bounded by its window rather than its file.
+var blockPool sync.Pool
+
+// getBuffer returns a buffer of length size, reusing a pooled one if it is large enough.
+func getBuffer(size int) []byte {
+	if buf, ok := blockPool.Get().(*[]byte); ok && cap(*buf) >= size {
+		return (*buf)[:size]
+	}
+
+	return make([]byte, size)
+}
+
+// putBuffer returns buf to the pool. It must not be used afterwards.
+func putBuffer(buf []byte) {
+	blockPool.Put(&buf)
+}
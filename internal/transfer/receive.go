@@ -0,0 +1,147 @@
+package transfer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	protocol "tftp/internal/protocol/parse"
+)
+
+// Receiver is the side of a transfer that receives DATA and sends ACKs:
+// the client for an RRQ, the server for a WRQ.
+type Receiver struct {
+	Config
+
+	// Request solicits block 1: an RRQ from a client, or ACK 0 or an OACK
+	// from a server. It is sent first and retransmitted on timeout until
+	// block 1 arrives.
+	Request []byte
+
+	// OnOptionAck, if set, handles an OACK answering Request and returns
+	// the Config negotiated by it. The receiver confirms it with ACK 0.
+	// An error aborts the transfer.
+	OnOptionAck func(protocol.OptionAck) (Config, error)
+
+	// OnBlock, if set, is called with the running Stats after each block
+	// is written.
+	OnBlock func(Stats)
+
+	// OnComplete, if set, is called once the final block is written and
+	// before it is acknowledged, so the data can be stored first. An error
+	// is sent to the peer in place of the final ACK and aborts the transfer.
+	OnComplete func() error
+}
+
+// Receive writes the peer's data to w until a short final block arrives.
+//
+// Blocks are acknowledged once per WindowSize in-order blocks. A block out
+// of order means an earlier one was lost, so the last in-order block is
+// acknowledged at once and the sender rolls back to the block after it
+// (RFC 7440).
+func (r *Receiver) Receive(ctx context.Context, conn Conn, w io.Writer) (Stats, error) {
+	var stats Stats
+	defer interruptOnDone(ctx, conn)()
+
+	// reply is retransmitted whenever the sender goes quiet.
+	reply := r.Request
+	expected := uint64(1)
+	sinceAck := 0
+	retries := 0
+	buf := getBuffer(r.BlockSize + 4) // Opcode and block number precede the data.
+	defer func() { putBuffer(buf) }()
+
+	if reply != nil {
+		if err := conn.Send(reply); err != nil {
+			return stats, fmt.Errorf("failed to send request: %w", err)
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return stats, cancel(ctx, conn)
+		default:
+			// Continue with transfer.
+		}
+
+		n, err := conn.Receive(buf, r.Timeout)
+		if err != nil {
+			if ctx.Err() != nil {
+				return stats, cancel(ctx, conn)
+			}
+			retries++
+			if retries >= r.MaxRetries {
+				return stats, fmt.Errorf("block %d: %w", expected, ErrMaxRetries)
+			}
+			if reply != nil {
+				conn.Send(reply)
+				stats.Retransmits++
+			}
+			sinceAck = 0
+			continue
+		}
+
+		packet, err := protocol.Parse(buf[:n])
+		if err != nil {
+			continue
+		}
+
+		switch p := packet.(type) {
+		case protocol.Error:
+			return stats, remoteError(p)
+		case protocol.OptionAck:
+			if r.OnOptionAck == nil || expected != 1 {
+				continue
+			}
+			config, err := r.OnOptionAck(p)
+			if err != nil {
+				return stats, abort(conn, optionError(err), err)
+			}
+			r.Config = config
+			putBuffer(buf)
+			buf = getBuffer(r.BlockSize + 4)
+			reply = protocol.Ack{BlockNumber: 0}.ToBinary()
+			conn.Send(reply)
+			retries = 0
+		case protocol.Data:
+			if p.BlockNumber != r.wireBlock(expected) {
+				// A duplicate or a block past a gap. Acknowledge the last
+				// in-order block so the sender resumes right after it.
+				reply = protocol.Ack{BlockNumber: r.wireBlock(expected - 1)}.ToBinary()
+				conn.Send(reply)
+				sinceAck = 0
+				continue
+			}
+
+			if _, err := w.Write(p.Data); err != nil {
+				return stats, abort(conn, protocol.ErrorFromOS(err), fmt.Errorf("failed to write block %d: %w", expected, err))
+			}
+			stats.Bytes += int64(len(p.Data))
+			stats.Blocks++
+			retries = 0
+			sinceAck++
+			if r.OnBlock != nil {
+				r.OnBlock(stats)
+			}
+
+			last := len(p.Data) < r.BlockSize
+			if last && r.OnComplete != nil {
+				if err := r.OnComplete(); err != nil {
+					return stats, abort(conn, protocol.ErrorFromOS(err), fmt.Errorf("failed to complete transfer: %w", err))
+				}
+			}
+			if last || sinceAck >= r.WindowSize {
+				reply = protocol.Ack{BlockNumber: p.BlockNumber}.ToBinary()
+				if err := conn.Send(reply); err != nil {
+					return stats, fmt.Errorf("failed to send ACK: %w", err)
+				}
+				sinceAck = 0
+			}
+
+			if last {
+				return stats, nil
+			}
+			expected++
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package transfer
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	protocol "tftp/internal/protocol/parse"
+	"time"
+)
+
+// Sender is the side of a transfer that sends DATA and receives ACKs:
+// the server for an RRQ, the client for a WRQ.
+type Sender struct {
+	Config
+
+	// Request, if set, is sent before any data and retransmitted until the
+	// peer answers with ACK 0 (or an OACK): a WRQ from a client, or an OACK
+	// from a server that accepted options.
+	Request []byte
+
+	// OnOptionAck, if set, handles an OACK answering Request and returns
+	// the Config negotiated by it. An error aborts the transfer.
+	OnOptionAck func(protocol.OptionAck) (Config, error)
+
+	// OnBlock, if set, is called with the running Stats each time an ACK
+	// moves the window forward.
+	OnBlock func(Stats)
+}
+
+// Send transmits r to the peer until a short final block is acknowledged.
+//
+// Up to WindowSize blocks are sent before waiting for an ACK. An ACK for a
+// block inside the window slides the window past it; the blocks after it
+// were lost or reordered, so the rest of the window is sent again (RFC 7440).
+// A duplicate ACK for the block before the window means its first block was
+// lost, and the window is sent again at once rather than after a timeout.
+func (s *Sender) Send(ctx context.Context, conn Conn, r io.Reader) (Stats, error) {
+	var stats Stats
+	defer interruptOnDone(ctx, conn)()
+
+	if s.Request != nil {
+		if err := s.handshake(ctx, conn, &stats); err != nil {
+			return stats, err
+		}
+	}
+
+	// window[i] holds the data of logical block base+i.
+	var window [][]byte
+	base := uint64(1)
+	highestSent := uint64(0)
+	// fastResent is the base last resent on a duplicate ACK. Each base gets
+	// one such resend, as the receiver answers every block after a gap with
+	// the same ACK.
+	fastResent := uint64(0)
+	retries := 0
+	eof := false
+	// ACKs are received into buf, and DATA packets are assembled in packet.
+	buf := getBuffer(protocol.DEFAULT_BLOCK_SIZE)
+	defer putBuffer(buf)
+	packet := getBuffer(s.BlockSize + 4)
+	defer putBuffer(packet)
+	defer func() {
+		for _, block := range window {
+			putBuffer(block)
+		}
+	}()
+
+	for {
+		for len(window) < s.WindowSize && !eof {
+			block := getBuffer(s.BlockSize)
+			n, err := io.ReadFull(r, block)
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				// A block shorter than BlockSize, possibly empty, ends the transfer.
+				eof = true
+			} else if err != nil {
+				return stats, abort(conn, protocol.ErrorFromOS(err), fmt.Errorf("failed to read block %d: %w", base+uint64(len(window)), err))
+			}
+			window = append(window, block[:n])
+		}
+
+		if len(window) == 0 {
+			return stats, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return stats, cancel(ctx, conn)
+		default:
+			// Continue with transfer.
+		}
+
+		for i, block := range window {
+			blockNum := base + uint64(i)
+			if err := conn.Send(dataPacket(packet, s.wireBlock(blockNum), block)); err != nil {
+				return stats, fmt.Errorf("failed to send block %d: %w", blockNum, err)
+			}
+
+			if blockNum <= highestSent {
+				stats.Retransmits++
+			} else {
+				highestSent = blockNum
+			}
+		}
+
+		acked, err := s.awaitAck(ctx, conn, buf, base, len(window), s.WindowSize > 1 && fastResent != base)
+		if err != nil {
+			return stats, err
+		}
+
+		if acked == resendWindow {
+			fastResent = base
+			continue
+		}
+
+		if acked == 0 {
+			retries++
+			if retries >= s.MaxRetries {
+				return stats, fmt.Errorf("block %d: %w", base, ErrMaxRetries)
+			}
+			continue
+		}
+		retries = 0
+
+		for _, block := range window[:acked] {
+			stats.Bytes += int64(len(block))
+			stats.Blocks++
+			putBuffer(block)
+		}
+		window = window[acked:]
+		base += uint64(acked)
+		if s.OnBlock != nil {
+			s.OnBlock(stats)
+		}
+	}
+}
+
+// resendWindow is returned by awaitAck when the peer asked for the window again.
+const resendWindow = -1
+
+// awaitAck waits for an ACK of a block in the window starting at base and
+// returns how many blocks it acknowledges. Zero means the peer went quiet
+// and the window should be sent again. If resendOnDuplicate is set, an ACK
+// for the block before base returns resendWindow.
+func (s *Sender) awaitAck(ctx context.Context, conn Conn, buf []byte, base uint64, windowLen int, resendOnDuplicate bool) (int, error) {
+	// Stray packets must not extend the wait past one timeout.
+	deadline := time.Now().Add(s.Timeout)
+	for {
+		select {
+		case <-ctx.Done():
+			return 0, cancel(ctx, conn)
+		default:
+			// Continue with transfer.
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return 0, nil
+		}
+
+		n, err := conn.Receive(buf, remaining)
+		if err != nil {
+			if ctx.Err() != nil {
+				return 0, cancel(ctx, conn)
+			}
+			return 0, nil
+		}
+
+		packet, err := protocol.Parse(buf[:n])
+		if err != nil {
+			continue
+		}
+
+		switch p := packet.(type) {
+		case protocol.Error:
+			return 0, remoteError(p)
+		case protocol.Ack:
+			for i := 0; i < windowLen; i++ {
+				if s.wireBlock(base+uint64(i)) == p.BlockNumber {
+					return i + 1, nil
+				}
+			}
+			if resendOnDuplicate && p.BlockNumber == s.wireBlock(base-1) {
+				return resendWindow, nil
+			}
+			// Any other duplicate ACK is ignored rather than answered, which
+			// avoids the Sorcerer's Apprentice syndrome. Lock-step transfers
+			// never resend on one for the same reason.
+		}
+	}
+}
+
+// handshake sends Request until the peer answers with ACK 0 or an OACK.
+func (s *Sender) handshake(ctx context.Context, conn Conn, stats *Stats) error {
+	buf := make([]byte, protocol.DEFAULT_BLOCK_SIZE)
+
+	for retries := 0; retries < s.MaxRetries; retries++ {
+		select {
+		case <-ctx.Done():
+			return cancel(ctx, conn)
+		default:
+			// Continue with transfer.
+		}
+
+		if err := conn.Send(s.Request); err != nil {
+			return fmt.Errorf("failed to send request: %w", err)
+		}
+		if retries > 0 {
+			stats.Retransmits++
+		}
+
+		n, err := conn.Receive(buf, s.Timeout)
+		if err != nil {
+			if ctx.Err() != nil {
+				return cancel(ctx, conn)
+			}
+			continue
+		}
+
+		packet, err := protocol.Parse(buf[:n])
+		if err != nil {
+			continue
+		}
+
+		switch p := packet.(type) {
+		case protocol.Error:
+			return remoteError(p)
+		case protocol.OptionAck:
+			if s.OnOptionAck == nil {
+				continue
+			}
+			config, err := s.OnOptionAck(p)
+			if err != nil {
+				return abort(conn, optionError(err), err)
+			}
+			s.Config = config
+			return nil
+		case protocol.Ack:
+			if p.BlockNumber == 0 {
+				return nil
+			}
+		}
+	}
+
+	return fmt.Errorf("no acknowledgment of request: %w", ErrMaxRetries)
+}
+
+// dataPacket serializes a DATA packet into buf, which must have room for
+// the 4 byte header and data, and returns the encoded packet.
+func dataPacket(buf []byte, blockNum uint16, data []byte) []byte {
+	binary.BigEndian.PutUint16(buf[0:2], uint16(protocol.DATA))
+	binary.BigEndian.PutUint16(buf[2:4], blockNum)
+	n := copy(buf[4:], data)
+
+	return buf[:4+n]
+}
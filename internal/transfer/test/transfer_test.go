@@ -0,0 +1,327 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	protocol "tftp/internal/protocol/parse"
+	"tftp/internal/transfer"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pipeConn is one end of an in-memory transport. drop, when set, decides
+// whether an outgoing packet is lost.
+type pipeConn struct {
+	in   chan []byte
+	out  chan []byte
+	drop func(packet []byte) bool
+}
+
+func newPipe() (*pipeConn, *pipeConn) {
+	a := make(chan []byte, 1024)
+	b := make(chan []byte, 1024)
+	return &pipeConn{in: a, out: b}, &pipeConn{in: b, out: a}
+}
+
+func (p *pipeConn) Send(packet []byte) error {
+	if p.drop != nil && p.drop(packet) {
+		return nil
+	}
+	p.out <- append([]byte{}, packet...)
+	return nil
+}
+
+func (p *pipeConn) Receive(buf []byte, timeout time.Duration) (int, error) {
+	select {
+	case packet := <-p.in:
+		return copy(buf, packet), nil
+	case <-time.After(timeout):
+		return 0, errors.New("timeout")
+	}
+}
+
+// dropOnce loses the first transmission of each listed DATA or ACK block.
+func dropOnce(opCode protocol.OpCode, blocks ...uint16) func([]byte) bool {
+	var mu sync.Mutex
+	pending := map[uint16]bool{}
+	for _, block := range blocks {
+		pending[block] = true
+	}
+
+	return func(packet []byte) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		if protocol.OpCode(binary.BigEndian.Uint16(packet)) != opCode {
+			return false
+		}
+		block := binary.BigEndian.Uint16(packet[2:])
+		if pending[block] {
+			delete(pending, block)
+			return true
+		}
+		return false
+	}
+}
+
+func runTransfer(t *testing.T, config transfer.Config, data []byte, senderEnd, receiverEnd *pipeConn) (transfer.Stats, transfer.Stats) {
+	ctx := context.Background()
+	sender := transfer.Sender{Config: config}
+	receiver := transfer.Receiver{Config: config, Request: protocol.Ack{BlockNumber: 0}.ToBinary()}
+
+	var sendStats transfer.Stats
+	var sendErr error
+	done := make(chan struct{})
+	go func() {
+		// The sender starts once ACK 0 arrives, as the server does for a WRQ.
+		buf := make([]byte, 4)
+		senderEnd.Receive(buf, time.Second)
+		sendStats, sendErr = sender.Send(ctx, senderEnd, bytes.NewReader(data))
+		close(done)
+	}()
+
+	var received bytes.Buffer
+	receiveStats, err := receiver.Receive(ctx, receiverEnd, &received)
+	<-done
+
+	assert.NoError(t, err)
+	assert.NoError(t, sendErr)
+	assert.True(t, bytes.Equal(data, received.Bytes()))
+	return sendStats, receiveStats
+}
+
+func testConfig(blockSize, windowSize int) transfer.Config {
+	return transfer.Config{
+		BlockSize:  blockSize,
+		WindowSize: windowSize,
+		Timeout:    50 * time.Millisecond,
+		MaxRetries: 5,
+	}
+}
+
+func randomData(size int) []byte {
+	data := make([]byte, size)
+	rand.Read(data)
+	return data
+}
+
+func TestLockStep(t *testing.T) {
+	for _, size := range []int{0, 1, 511, 512, 513, 5000} {
+		senderEnd, receiverEnd := newPipe()
+		data := randomData(size)
+		sendStats, receiveStats := runTransfer(t, testConfig(512, 1), data, senderEnd, receiverEnd)
+
+		assert.Equal(t, uint64(size/512+1), receiveStats.Blocks)
+		assert.Equal(t, int64(size), sendStats.Bytes)
+		assert.Equal(t, 0, sendStats.Retransmits)
+	}
+}
+
+func TestWindowed(t *testing.T) {
+	for _, windowSize := range []int{2, 4, 16} {
+		senderEnd, receiverEnd := newPipe()
+		data := randomData(100*64 + 17)
+		sendStats, _ := runTransfer(t, testConfig(64, windowSize), data, senderEnd, receiverEnd)
+
+		assert.Equal(t, 0, sendStats.Retransmits)
+	}
+}
+
+func TestWindowedLostData(t *testing.T) {
+	senderEnd, receiverEnd := newPipe()
+	// Losing a block mid-window makes the receiver acknowledge the block
+	// before it, and the sender rolls back to the lost one.
+	senderEnd.drop = dropOnce(protocol.DATA, 3, 10, 11, 40)
+	data := randomData(50 * 32)
+	sendStats, receiveStats := runTransfer(t, testConfig(32, 8), data, senderEnd, receiverEnd)
+
+	assert.Equal(t, uint64(51), receiveStats.Blocks)
+	assert.Greater(t, sendStats.Retransmits, 0)
+}
+
+func TestWindowedLostAck(t *testing.T) {
+	senderEnd, receiverEnd := newPipe()
+	// A lost ACK leaves the sender waiting until it times out and resends the window.
+	receiverEnd.drop = dropOnce(protocol.ACK, 8, 24)
+	data := randomData(40 * 32)
+	sendStats, _ := runTransfer(t, testConfig(32, 8), data, senderEnd, receiverEnd)
+
+	assert.Greater(t, sendStats.Retransmits, 0)
+}
+
+func TestLockStepLoss(t *testing.T) {
+	senderEnd, receiverEnd := newPipe()
+	senderEnd.drop = dropOnce(protocol.DATA, 2, 5)
+	receiverEnd.drop = dropOnce(protocol.ACK, 3)
+	data := randomData(10 * 512)
+	runTransfer(t, testConfig(512, 1), data, senderEnd, receiverEnd)
+}
+
+func TestRemoteError(t *testing.T) {
+	senderEnd, receiverEnd := newPipe()
+	senderEnd.Send(protocol.NewError(protocol.ERR_FILE_NOT_FOUND).ToBinary())
+
+	receiver := transfer.Receiver{Config: testConfig(512, 1)}
+	_, err := receiver.Receive(context.Background(), receiverEnd, &bytes.Buffer{})
+
+	var remoteErr *transfer.RemoteError
+	assert.ErrorAs(t, err, &remoteErr)
+	assert.Equal(t, uint16(1), remoteErr.Code)
+}
+
+func TestFailedCompletionReplacesFinalAck(t *testing.T) {
+	senderEnd, receiverEnd := newPipe()
+	config := testConfig(32, 4)
+	sender := transfer.Sender{Config: config}
+	receiver := transfer.Receiver{
+		Config:     config,
+		OnComplete: func() error { return syscall.ENOSPC },
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := sender.Send(context.Background(), senderEnd, bytes.NewReader(randomData(100)))
+		done <- err
+	}()
+	_, err := receiver.Receive(context.Background(), receiverEnd, &bytes.Buffer{})
+	assert.ErrorIs(t, err, syscall.ENOSPC)
+
+	var remoteErr *transfer.RemoteError
+	if assert.ErrorAs(t, <-done, &remoteErr) {
+		assert.Equal(t, protocol.ERR_DISK_FULL, remoteErr.Code)
+	}
+}
+
+func TestMaxRetries(t *testing.T) {
+	_, receiverEnd := newPipe()
+	receiver := transfer.Receiver{Config: testConfig(512, 1)}
+	_, err := receiver.Receive(context.Background(), receiverEnd, &bytes.Buffer{})
+
+	assert.ErrorIs(t, err, transfer.ErrMaxRetries)
+}
+
+func TestLocalFailureSendsError(t *testing.T) {
+	senderEnd, receiverEnd := newPipe()
+	go func() {
+		senderEnd.Send(protocol.Data{BlockNumber: 1, Data: make([]byte, 512)}.ToBinary())
+	}()
+
+	receiver := transfer.Receiver{Config: testConfig(512, 1)}
+	_, err := receiver.Receive(context.Background(), receiverEnd, failingWriter{os.ErrPermission})
+	assert.ErrorIs(t, err, os.ErrPermission)
+
+	buf := make([]byte, 512)
+	n, err := senderEnd.Receive(buf, time.Second)
+	assert.NoError(t, err)
+	packet, err := protocol.Parse(buf[:n])
+	assert.NoError(t, err)
+	assert.Equal(t, protocol.NewError(protocol.ERR_ACCESS_VIOLATION), packet)
+}
+
+type failingWriter struct{ err error }
+
+func (f failingWriter) Write(p []byte) (int, error) { return 0, f.err }
+
+func TestBlockRollover(t *testing.T) {
+	for _, rollover := range []uint16{0, 1} {
+		senderEnd, receiverEnd := newPipe()
+
+		// Record the block that follows 65535 and lose packets around the wrap.
+		// A rollback after a loss may resend blocks below 65535, which are skipped.
+		var mu sync.Mutex
+		var previous uint16
+		var wrapped []uint16
+		dropData := dropOnce(protocol.DATA, 65534, 65535)
+		senderEnd.drop = func(packet []byte) bool {
+			mu.Lock()
+			block := binary.BigEndian.Uint16(packet[2:])
+			if previous == 65535 && block < 65535-16 {
+				wrapped = append(wrapped, block)
+			}
+			previous = block
+			mu.Unlock()
+			return dropData(packet)
+		}
+		receiverEnd.drop = dropOnce(protocol.ACK, 65535)
+
+		config := testConfig(8, 16)
+		config.Rollover = rollover
+		data := randomData(70000*8 + 3)
+		_, receiveStats := runTransfer(t, config, data, senderEnd, receiverEnd)
+
+		assert.Equal(t, uint64(70001), receiveStats.Blocks)
+		assert.NotEmpty(t, wrapped)
+		for _, block := range wrapped {
+			assert.Equal(t, rollover, block)
+		}
+	}
+}
+
+func TestUDPConnLocksToFirstReply(t *testing.T) {
+	listen := func() *net.UDPConn {
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+	local, listener, session, stray := listen(), listen(), listen(), listen()
+
+	// Requests go to the listener until the server's TID is learned from its reply.
+	conn := transfer.NewUDPConn(local, listener.LocalAddr().(*net.UDPAddr))
+	require.NoError(t, conn.Send([]byte("request")))
+	buf := make([]byte, 64)
+	n, _, err := listener.ReadFromUDP(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "request", string(buf[:n]))
+
+	session.WriteToUDP([]byte("first"), local.LocalAddr().(*net.UDPAddr))
+	n, err = conn.Receive(buf, time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, "first", string(buf[:n]))
+
+	// Another TID is told it is unknown while the transfer waits on.
+	stray.WriteToUDP([]byte("stray"), local.LocalAddr().(*net.UDPAddr))
+	session.WriteToUDP([]byte("second"), local.LocalAddr().(*net.UDPAddr))
+	n, err = conn.Receive(buf, time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, "second", string(buf[:n]))
+
+	stray.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err = stray.ReadFromUDP(buf)
+	require.NoError(t, err)
+	packet, err := protocol.Parse(buf[:n])
+	require.NoError(t, err)
+	assert.Equal(t, protocol.NewError(protocol.ERR_UNKNOWN_TID), packet)
+
+	require.NoError(t, conn.Send([]byte("reply")))
+	session.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err = session.ReadFromUDP(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "reply", string(buf[:n]))
+}
+
+func TestLostWindowStartResentWithoutTimeout(t *testing.T) {
+	senderEnd, receiverEnd := newPipe()
+	// Losing the first block of a window makes the receiver repeat the ACK
+	// for the block before it, which prompts an immediate resend.
+	senderEnd.drop = dropOnce(protocol.DATA, 1, 9, 17)
+	config := testConfig(32, 8)
+	config.Timeout = 5 * time.Second
+	data := randomData(30 * 32)
+
+	started := time.Now()
+	sendStats, receiveStats := runTransfer(t, config, data, senderEnd, receiverEnd)
+	assert.Less(t, time.Since(started), config.Timeout)
+	assert.Equal(t, uint64(31), receiveStats.Blocks)
+	assert.Greater(t, sendStats.Retransmits, 0)
+}
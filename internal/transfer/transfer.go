@@ -0,0 +1,206 @@
+// Package transfer implements the sending and receiving halves of a TFTP
+// transfer, shared by the client and the server.
+//
+// Both halves run over a Conn bound to a single peer, move data in blocks
+// of the negotiated blksize (RFC 2348), and send windowsize blocks per
+// acknowledgment (RFC 7440). A window size of 1 is RFC 1350 lock-step.
+package transfer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"sync/atomic"
+	protocol "tftp/internal/protocol/parse"
+	"time"
+)
+
+// Config holds the parameters a transfer runs with after option negotiation.
+type Config struct {
+	BlockSize  int           // Bytes of data per DATA packet.
+	WindowSize int           // DATA packets sent per ACK.
+	Timeout    time.Duration // How long to wait for the peer before retransmitting.
+	MaxRetries int           // Consecutive timeouts tolerated before giving up.
+
+	// Rollover is the block number that follows 65535 on the wire, 0 or 1.
+	// Blocks are counted with 64 bits internally, so files of any size can
+	// be moved as long as both sides agree on it.
+	Rollover uint16
+}
+
+// DefaultConfig returns the RFC 1350 parameters used when nothing is negotiated.
+func DefaultConfig() Config {
+	return Config{
+		BlockSize:  protocol.DEFAULT_BLOCK_SIZE,
+		WindowSize: protocol.DEFAULT_WINDOW_SIZE,
+		Timeout:    5 * time.Second,
+		MaxRetries: 5,
+	}
+}
+
+// wireBlock maps a logical block number to the 16-bit number sent on the wire.
+// Blocks 0 to 65535 map to themselves; after that numbering restarts at Rollover.
+func (c Config) wireBlock(logical uint64) uint16 {
+	if logical <= math.MaxUint16 {
+		return uint16(logical)
+	}
+
+	period := uint64(math.MaxUint16 + 1 - uint32(c.Rollover))
+	return c.Rollover + uint16((logical-(math.MaxUint16+1))%period)
+}
+
+// Stats describes a finished (or failed) transfer.
+type Stats struct {
+	Bytes       int64  // Bytes of file data moved.
+	Blocks      uint64 // DATA blocks moved, counting each block once.
+	Retransmits int    // Packets sent again after a timeout or rollback.
+}
+
+// ErrMaxRetries is returned when the peer stops answering.
+var ErrMaxRetries = errors.New("max retries reached")
+
+// ErrCancelled is returned when the context of a transfer is done. The
+// error also wraps the context's error, context.Canceled or
+// context.DeadlineExceeded.
+var ErrCancelled = errors.New("transfer cancelled")
+
+// RemoteError is returned when the peer aborts the transfer with an ERROR packet.
+type RemoteError struct {
+	Code uint16
+	Msg  string
+}
+
+func (e *RemoteError) Error() string {
+	return fmt.Sprintf("remote error %d: %s", e.Code, e.Msg)
+}
+
+// Conn exchanges packets with the single peer of a transfer.
+type Conn interface {
+	Send(packet []byte) error
+	// Receive reads one packet into buf, waiting at most timeout.
+	Receive(buf []byte, timeout time.Duration) (int, error)
+}
+
+// interrupter is implemented by a Conn whose blocked Receive can be cut
+// short, so cancellation does not wait out a full timeout.
+type interrupter interface {
+	Interrupt()
+}
+
+// interruptOnDone interrupts conn's Receive once ctx is done, if conn
+// supports it. The returned function stops watching ctx.
+func interruptOnDone(ctx context.Context, conn Conn) func() {
+	i, ok := conn.(interrupter)
+	if !ok {
+		return func() {}
+	}
+
+	stop := context.AfterFunc(ctx, i.Interrupt)
+	return func() { stop() }
+}
+
+type udpConn struct {
+	conn *net.UDPConn
+	peer *net.UDPAddr
+	// locked is set once the peer's TID is known. From then on packets
+	// from any other address are answered with ERROR 5 and ignored.
+	locked bool
+	// interrupted is set once Receive must stop blocking for good.
+	interrupted atomic.Bool
+}
+
+// NewUDPConn returns a Conn over conn.
+//
+// A nil peer means conn is connected to the peer already. Otherwise packets
+// are sent to peer until the first reply arrives, after which the Conn
+// follows the address the reply came from; this is how a client moves from
+// the server's well-known port to the TID the server picked.
+func NewUDPConn(conn *net.UDPConn, peer *net.UDPAddr) Conn {
+	return &udpConn{conn: conn, peer: peer, locked: peer == nil}
+}
+
+// NewPeerConn returns a Conn over conn exchanging packets with peer, whose
+// TID is already known, as a server's is from the request.
+func NewPeerConn(conn *net.UDPConn, peer *net.UDPAddr) Conn {
+	return &udpConn{conn: conn, peer: peer, locked: true}
+}
+
+func (c *udpConn) Send(packet []byte) error {
+	if c.peer == nil {
+		_, err := c.conn.Write(packet)
+		return err
+	}
+
+	_, err := c.conn.WriteToUDP(packet, c.peer)
+	return err
+}
+
+func (c *udpConn) Receive(buf []byte, timeout time.Duration) (int, error) {
+	c.conn.SetReadDeadline(time.Now().Add(timeout))
+	// Checked after setting the deadline, so an Interrupt racing with it
+	// is either seen here or overrides the deadline just set.
+	if c.interrupted.Load() {
+		return 0, os.ErrDeadlineExceeded
+	}
+
+	if c.peer == nil {
+		return c.conn.Read(buf)
+	}
+
+	for {
+		n, addr, err := c.conn.ReadFromUDP(buf)
+		if err != nil {
+			return 0, err
+		}
+
+		if !c.locked {
+			c.peer = addr
+			c.locked = true
+			return n, nil
+		}
+		if addr.IP.Equal(c.peer.IP) && addr.Port == c.peer.Port {
+			return n, nil
+		}
+
+		// RFC 1350: a packet from another TID is answered without
+		// disturbing the transfer, and the wait continues until the
+		// same deadline.
+		c.conn.WriteToUDP(errUnknownTID.ToBinary(), addr)
+	}
+}
+
+// errUnknownTID answers a packet that does not belong to the transfer.
+var errUnknownTID = protocol.NewError(protocol.ERR_UNKNOWN_TID)
+
+// remoteError converts a received ERROR packet into a *RemoteError.
+func remoteError(packet protocol.Error) error {
+	return &RemoteError{Code: packet.ErrorCode, Msg: packet.ErrorMsg}
+}
+
+func (c *udpConn) Interrupt() {
+	c.interrupted.Store(true)
+	c.conn.SetReadDeadline(time.Now())
+}
+
+// errCancelled tells the peer a transfer was cancelled locally.
+var errCancelled = protocol.Error{ErrorCode: protocol.ERR_NOT_DEFINED, ErrorMsg: "transfer cancelled"}
+
+// cancel tells the peer the transfer was cancelled locally and returns an
+// error wrapping ErrCancelled and the reason ctx is done.
+func cancel(ctx context.Context, conn Conn) error {
+	return abort(conn, errCancelled, fmt.Errorf("%w: %w", ErrCancelled, ctx.Err()))
+}
+
+// abort tells the peer why the transfer is ending with an ERROR packet and returns err.
+func abort(conn Conn, packet protocol.Error, err error) error {
+	conn.Send(packet.ToBinary())
+	return err
+}
+
+// optionError is the ERROR packet rejecting an OACK (RFC 2347).
+func optionError(err error) protocol.Error {
+	return protocol.Error{ErrorCode: protocol.ERR_OPTION_NEGOTIATION, ErrorMsg: err.Error()}
+}
@@ -0,0 +1,115 @@
+package test
+
+import (
+	"net"
+	"testing"
+
+	"tftp/internal/utils"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePortRange(t *testing.T) {
+	r, err := utils.ParsePortRange("50000-50099")
+	require.NoError(t, err)
+	assert.Equal(t, utils.PortRange{Min: 50000, Max: 50099}, r)
+
+	r, err = utils.ParsePortRange("6969-6969")
+	require.NoError(t, err)
+	assert.Equal(t, utils.PortRange{Min: 6969, Max: 6969}, r)
+
+	for _, value := range []string{"", "50000", "50099-50000", "0-10", "65000-70000", "a-b", "-5-10"} {
+		_, err := utils.ParsePortRange(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestPortAllocator(t *testing.T) {
+	ports := freePorts(t, 3)
+	allocator := utils.NewPortAllocator(ports)
+	local := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}
+	remote := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 69}
+
+	var releases []func()
+	seen := map[int]bool{}
+	for range 3 {
+		conn, release, err := allocator.Listen(local, remote)
+		require.NoError(t, err)
+		port := conn.LocalAddr().(*net.UDPAddr).Port
+		assert.False(t, seen[port], "port %d handed out twice", port)
+		assert.True(t, port >= ports.Min && port <= ports.Max)
+		seen[port] = true
+		releases = append(releases, release)
+	}
+	assert.Equal(t, 3, allocator.InUse())
+
+	_, _, err := allocator.Listen(local, remote)
+	assert.ErrorIs(t, err, utils.ErrPortsExhausted)
+
+	releases[0]()
+	assert.Equal(t, 2, allocator.InUse())
+	conn, release, err := allocator.Listen(local, remote)
+	require.NoError(t, err)
+	assert.True(t, seen[conn.LocalAddr().(*net.UDPAddr).Port])
+	release()
+	for _, release := range releases[1:] {
+		release()
+	}
+	assert.Equal(t, 0, allocator.InUse())
+}
+
+func TestPortAllocatorSkipsPortsInUse(t *testing.T) {
+	ports := freePorts(t, 2)
+	taken, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: ports.Min})
+	require.NoError(t, err)
+	defer taken.Close()
+
+	allocator := utils.NewPortAllocator(ports)
+	for range 5 {
+		conn, release, err := allocator.Listen(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		require.NoError(t, err)
+		assert.Equal(t, ports.Max, conn.LocalAddr().(*net.UDPAddr).Port)
+		release()
+	}
+}
+
+func TestPortAllocatorRejectsEmptyRange(t *testing.T) {
+	local := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}
+	for _, ports := range []utils.PortRange{{Min: 50099, Max: 50000}, {Min: 0, Max: -1}, {Min: 60000, Max: 70000}} {
+		_, _, err := utils.NewPortAllocator(ports).Listen(local, local)
+		assert.Error(t, err, ports.String())
+		assert.NotErrorIs(t, err, utils.ErrPortsExhausted, ports.String())
+	}
+}
+
+// freePorts finds n consecutive ports that are currently free on loopback.
+func freePorts(t *testing.T, n int) utils.PortRange {
+	t.Helper()
+
+	for attempt := 0; attempt < 100; attempt++ {
+		probe, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		require.NoError(t, err)
+		first := probe.LocalAddr().(*net.UDPAddr).Port
+		probe.Close()
+		if first+n-1 > 65535 {
+			continue
+		}
+
+		free := true
+		for port := first; port < first+n; port++ {
+			conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port})
+			if err != nil {
+				free = false
+				break
+			}
+			conn.Close()
+		}
+		if free {
+			return utils.PortRange{Min: first, Max: first + n - 1}
+		}
+	}
+
+	t.Fatal("no free port range found")
+	return utils.PortRange{}
+}
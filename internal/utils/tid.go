@@ -1,10 +1,151 @@
 package utils
 
 import (
+	"errors"
+	"fmt"
 	"math/rand/v2"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 )
 
-func GenerateTID() int {
-	TID := 49152 + rand.IntN(65536-49152) // [49152, 65535] is suggested in RFC 6335 as ephemeral ports for dynamic assignment.
-	return TID
+// PortRange is an inclusive range of ports for transfer sockets, each
+// one's TID. The zero value means DEFAULT_PORT_RANGE.
+type PortRange struct {
+	Min, Max int
+}
+
+// DEFAULT_PORT_RANGE is [49152, 65535], suggested in RFC 6335 as ephemeral
+// ports for dynamic assignment.
+var DEFAULT_PORT_RANGE = PortRange{Min: 49152, Max: 65535}
+
+// ErrPortsExhausted is returned when every port in the range is in use.
+var ErrPortsExhausted = errors.New("no free port in range")
+
+// ParsePortRange parses a range written as "min-max", such as "50000-50099".
+func ParsePortRange(value string) (PortRange, error) {
+	low, high, found := strings.Cut(value, "-")
+	if !found {
+		return PortRange{}, fmt.Errorf("port range %q must be min-max", value)
+	}
+
+	min, errMin := strconv.Atoi(strings.TrimSpace(low))
+	max, errMax := strconv.Atoi(strings.TrimSpace(high))
+	if errMin != nil || errMax != nil {
+		return PortRange{}, fmt.Errorf("port range %q must be min-max", value)
+	}
+
+	r := PortRange{Min: min, Max: max}
+	return r, r.Validate()
+}
+
+// Validate reports whether the range is empty or holds invalid ports.
+func (r PortRange) Validate() error {
+	if r.Min < 1 || r.Max > 65535 || r.Min > r.Max {
+		return fmt.Errorf("port range %v must be within [1, 65535] with min <= max", r)
+	}
+
+	return nil
+}
+
+func (r PortRange) String() string {
+	return fmt.Sprintf("%d-%d", r.Min, r.Max)
+}
+
+func (r PortRange) size() int {
+	return r.Max - r.Min + 1
+}
+
+// PortAllocator hands out TIDs from a range, tracking which ports its
+// sockets hold so concurrent transfers never collide, and skipping ports
+// other programs hold.
+type PortAllocator struct {
+	ports PortRange
+
+	mu    sync.Mutex
+	inUse map[int]struct{}
+}
+
+// NewPortAllocator returns an allocator for ports, or DEFAULT_PORT_RANGE
+// if ports is the zero value.
+func NewPortAllocator(ports PortRange) *PortAllocator {
+	if ports == (PortRange{}) {
+		ports = DEFAULT_PORT_RANGE
+	}
+
+	return &PortAllocator{ports: ports, inUse: make(map[int]struct{})}
+}
+
+// Listen opens a socket on laddr's address and a free port of the range,
+// starting from a random one so TIDs are hard to guess. The network is
+// chosen to match remote's address family. The returned function closes
+// the socket and frees its port. If no port is free, the error wraps
+// ErrPortsExhausted; a range that fails Validate is an error too.
+func (a *PortAllocator) Listen(laddr, remote *net.UDPAddr) (*net.UDPConn, func(), error) {
+	network := "udp6"
+	if remote.IP.To4() != nil {
+		network = "udp4"
+	}
+
+	// An empty range has no port to start from.
+	if err := a.ports.Validate(); err != nil {
+		return nil, nil, err
+	}
+
+	addr := *laddr
+	size := a.ports.size()
+	start := rand.IntN(size)
+	for i := range size {
+		port := a.ports.Min + (start+i)%size
+		if !a.reserve(port) {
+			continue
+		}
+
+		addr.Port = port
+		conn, err := net.ListenUDP(network, &addr)
+		if errors.Is(err, syscall.EADDRINUSE) {
+			a.free(port)
+			continue
+		}
+		if err != nil {
+			a.free(port)
+			return nil, nil, err
+		}
+
+		release := func() {
+			conn.Close()
+			a.free(port)
+		}
+		return conn, release, nil
+	}
+
+	return nil, nil, fmt.Errorf("%w %v", ErrPortsExhausted, a.ports)
+}
+
+// InUse returns how many ports the allocator's sockets hold.
+func (a *PortAllocator) InUse() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return len(a.inUse)
+}
+
+func (a *PortAllocator) reserve(port int) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, taken := a.inUse[port]; taken {
+		return false
+	}
+	a.inUse[port] = struct{}{}
+	return true
+}
+
+func (a *PortAllocator) free(port int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	delete(a.inUse, port)
 }
@@ -0,0 +1,153 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"tftp/internal/client"
+	"tftp/pkg/tftp"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serve runs srv on a free localhost port, shutting it down when the test ends.
+func serve(t *testing.T, srv *tftp.Server) string {
+	t.Helper()
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+
+	go srv.Serve(context.Background(), conn)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		srv.Shutdown(ctx)
+	})
+
+	return conn.LocalAddr().String()
+}
+
+// upload collects a WRQ's data and hands it over once committed, which
+// happens before the client sees the final ACK.
+type upload struct {
+	bytes.Buffer
+	committed chan string
+}
+
+func (u *upload) Commit() error {
+	u.committed <- u.String()
+	return nil
+}
+
+func TestServeMuxRoutesByPattern(t *testing.T) {
+	images := tftp.NewMemoryBackend()
+	images.Put("kernel.bin", []byte("kernel"))
+
+	requests := make(chan *tftp.Request, 1)
+	uploads := make(chan string, 1)
+	mux := tftp.NewServeMux()
+	mux.HandleReadFunc("pxelinux.cfg/", func(req *tftp.Request) (io.Reader, error) {
+		requests <- req
+		return strings.NewReader("config for " + req.RemoteAddr.IP.String()), nil
+	})
+	mux.HandleRead("*.bin", tftp.BackendHandler{Backend: images})
+	mux.HandleWriteFunc("logs/*", func(req *tftp.Request) (io.Writer, error) {
+		return &upload{committed: uploads}, nil
+	})
+	addr := serve(t, tftp.NewServer(nil, mux, mux))
+
+	dir := t.TempDir()
+	cli := client.New(addr)
+	_, err := cli.Get(context.Background(), "pxelinux.cfg/default", filepath.Join(dir, "default"))
+	require.NoError(t, err)
+	assertFile(t, filepath.Join(dir, "default"), "config for 127.0.0.1")
+	req := <-requests
+	assert.Equal(t, "pxelinux.cfg/default", req.Filename)
+	assert.Equal(t, tftp.MODE_OCTET, req.Mode)
+
+	_, err = cli.Get(context.Background(), "kernel.bin", filepath.Join(dir, "kernel.bin"))
+	require.NoError(t, err)
+	assertFile(t, filepath.Join(dir, "kernel.bin"), "kernel")
+
+	local := filepath.Join(dir, "boot.log")
+	require.NoError(t, os.WriteFile(local, []byte("booted"), 0o644))
+	_, err = cli.Put(context.Background(), "logs/boot.log", local)
+	require.NoError(t, err)
+	select {
+	case contents := <-uploads:
+		assert.Equal(t, "booted", contents)
+	default:
+		t.Fatal("upload was not committed")
+	}
+
+	var remoteErr *tftp.TFTPError
+	_, err = cli.Get(context.Background(), "initrd.img", filepath.Join(dir, "initrd.img"))
+	if assert.ErrorAs(t, err, &remoteErr) {
+		assert.Equal(t, tftp.ERR_FILE_NOT_FOUND, remoteErr.Code)
+	}
+	_, err = cli.Put(context.Background(), "kernel.bin", local)
+	if assert.ErrorAs(t, err, &remoteErr) {
+		assert.Equal(t, tftp.ERR_ACCESS_VIOLATION, remoteErr.Code)
+	}
+}
+
+func TestServeMuxMatchesCleanedNames(t *testing.T) {
+	requests := make(chan string, 1)
+	mux := tftp.NewServeMux()
+	mux.HandleReadFunc("pxelinux.cfg/", func(req *tftp.Request) (io.Reader, error) {
+		requests <- req.Filename
+		return strings.NewReader("config"), nil
+	})
+	addr := serve(t, tftp.NewServer(nil, mux, mux))
+
+	dir := t.TempDir()
+	cli := client.New(addr)
+	_, err := cli.Get(context.Background(), "pxelinux.cfg/./default", filepath.Join(dir, "default"))
+	require.NoError(t, err)
+	assert.Equal(t, "pxelinux.cfg/./default", <-requests, "handlers see the name as sent")
+
+	for filename, code := range map[string]uint16{
+		// Cleaned to secret.key, which no pattern matches.
+		"pxelinux.cfg/../secret.key":    tftp.ERR_FILE_NOT_FOUND,
+		"pxelinux.cfg/../../etc/passwd": tftp.ERR_ACCESS_VIOLATION,
+		"../pxelinux.cfg/default":       tftp.ERR_ACCESS_VIOLATION,
+	} {
+		var remoteErr *tftp.TFTPError
+		_, err := cli.Get(context.Background(), filename, filepath.Join(dir, "out"))
+		if assert.ErrorAs(t, err, &remoteErr, filename) {
+			assert.Equal(t, code, remoteErr.Code, filename)
+		}
+	}
+	assert.Empty(t, requests)
+}
+
+func TestHandlerErrorsReachTheClient(t *testing.T) {
+	reads := tftp.ReadHandlerFunc(func(req *tftp.Request) (io.Reader, error) {
+		return nil, tftp.Error{ErrorCode: tftp.ERR_NO_SUCH_USER, ErrorMsg: "unknown host"}
+	})
+	addr := serve(t, tftp.NewServer(nil, reads, nil))
+
+	dir := t.TempDir()
+	var remoteErr *tftp.TFTPError
+	_, err := client.New(addr).Get(context.Background(), "anything", filepath.Join(dir, "anything"))
+	if assert.ErrorAs(t, err, &remoteErr) {
+		assert.Equal(t, tftp.ERR_NO_SUCH_USER, remoteErr.Code)
+		assert.Equal(t, "unknown host", remoteErr.Message)
+	}
+}
+
+func assertFile(t *testing.T, path, contents string) {
+	t.Helper()
+
+	got, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, contents, string(got))
+}
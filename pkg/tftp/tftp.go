@@ -0,0 +1,193 @@
+// Package tftp embeds a TFTP server or client (RFC 1350, with the option
+// extensions of RFC 2347, 2348, 2349 and 7440) in another program.
+//
+// As with net/http, a Server hands each request to a handler that decides
+// what to serve: a ReadHandler returns the contents for an RRQ and a
+// WriteHandler the destination of a WRQ. ServeMux routes requests to
+// handlers by filename, and the Backend implementations serve a directory,
+// memory or an fs.FS such as an embed.FS.
+//
+//	mux := tftp.NewServeMux()
+//	mux.HandleReadFunc("pxelinux.cfg/*", func(req *tftp.Request) (io.Reader, error) {
+//		return strings.NewReader(bootConfig(req.RemoteAddr)), nil
+//	})
+//	mux.HandleRead("*", tftp.BackendHandler{Backend: tftp.NewFSBackend(images)})
+//
+//	srv := tftp.NewServer([]string{"[::]:69"}, mux, nil)
+//	err := srv.Serve(ctx, conn)
+//
+// A Client fetches and sends files, either to paths or straight to and
+// from an io.Writer or io.Reader:
+//
+//	var config bytes.Buffer
+//	result, err := tftp.NewClient("192.0.2.1:69").GetTo(ctx, "router1.cfg", &config)
+package tftp
+
+import (
+	"io"
+	"io/fs"
+	"tftp/internal/client"
+	protocol "tftp/internal/protocol/parse"
+	"tftp/internal/server"
+	"tftp/internal/utils"
+)
+
+type (
+	// Server reads requests from one or more sockets and runs a transfer
+	// session for each; see Serve and Shutdown.
+	Server = server.Server
+
+	// Request describes an RRQ or WRQ being handed to a handler.
+	Request = server.Request
+
+	// ReadHandler serves RRQs.
+	ReadHandler = server.ReadHandler
+	// WriteHandler serves WRQs.
+	WriteHandler = server.WriteHandler
+	// ReadHandlerFunc adapts a function to a ReadHandler.
+	ReadHandlerFunc = server.ReadHandlerFunc
+	// WriteHandlerFunc adapts a function to a WriteHandler.
+	WriteHandlerFunc = server.WriteHandlerFunc
+
+	// ServeMux routes requests to handlers by filename pattern.
+	ServeMux = server.ServeMux
+
+	// Backend stores the files a Server reads and writes.
+	Backend = server.Backend
+	// WriteFile receives an upload to a Backend.
+	WriteFile = server.WriteFile
+	// BackendHandler serves reads and writes from a Backend.
+	BackendHandler = server.BackendHandler
+	// LocalBackend serves a directory tree.
+	LocalBackend = server.LocalBackend
+	// MemoryBackend keeps files in memory.
+	MemoryBackend = server.MemoryBackend
+	// FSBackend serves an fs.FS read-only.
+	FSBackend = server.FSBackend
+
+	// ACL decides which hosts may read and write which files.
+	ACL = server.ACL
+	// ACLRule allows or denies requests from a network.
+	ACLRule = server.ACLRule
+
+	// PortRange is the range of ports for session sockets, the server's TIDs.
+	PortRange = utils.PortRange
+
+	// WritePolicy decides whether a WRQ may create or overwrite its target.
+	WritePolicy = server.WritePolicy
+
+	// Client reads files from and writes files to a server.
+	Client = client.Client
+	// Result describes a transfer the Client completed.
+	Result = client.Result
+	// TFTPError is returned by a Client when the server sends an ERROR.
+	TFTPError = client.TFTPError
+
+	// Error is a TFTP ERROR packet. Handlers return one, or an error
+	// wrapping one, to choose the error code sent to the client.
+	Error = protocol.Error
+)
+
+// Error codes defined by RFC 1350 and RFC 2347.
+const (
+	ERR_NOT_DEFINED        = protocol.ERR_NOT_DEFINED
+	ERR_FILE_NOT_FOUND     = protocol.ERR_FILE_NOT_FOUND
+	ERR_ACCESS_VIOLATION   = protocol.ERR_ACCESS_VIOLATION
+	ERR_DISK_FULL          = protocol.ERR_DISK_FULL
+	ERR_ILLEGAL_OPERATION  = protocol.ERR_ILLEGAL_OPERATION
+	ERR_UNKNOWN_TID        = protocol.ERR_UNKNOWN_TID
+	ERR_FILE_EXISTS        = protocol.ERR_FILE_EXISTS
+	ERR_NO_SUCH_USER       = protocol.ERR_NO_SUCH_USER
+	ERR_OPTION_NEGOTIATION = protocol.ERR_OPTION_NEGOTIATION
+)
+
+// Write policies for Server.WritePolicy.
+const (
+	WRITE_ALLOW_ALL      = server.WRITE_ALLOW_ALL
+	WRITE_READ_ONLY      = server.WRITE_READ_ONLY
+	WRITE_CREATE_ONLY    = server.WRITE_CREATE_ONLY
+	WRITE_OVERWRITE_ONLY = server.WRITE_OVERWRITE_ONLY
+)
+
+// Transfer modes found in Request.Mode.
+const (
+	MODE_OCTET    = protocol.MODE_OCTET
+	MODE_NETASCII = protocol.MODE_NETASCII
+)
+
+// DEFAULT_ADDRESS is where ListenAndServe listens when no addresses are
+// given: port 69 on the loopback interface only.
+const DEFAULT_ADDRESS = server.DEFAULT_ADDRESS
+
+// ErrServerClosed is returned by Serve and ListenAndServe after Shutdown.
+var ErrServerClosed = server.ErrServerClosed
+
+// Errors a Client transfer can fail with, for use with errors.Is.
+var (
+	ErrTimeout    = client.ErrTimeout
+	ErrCancelled  = client.ErrCancelled
+	ErrUnknownTID = client.ErrUnknownTID
+	ErrProtocol   = client.ErrProtocol
+	ErrLocalIO    = client.ErrLocalIO
+)
+
+// NewServer returns a server that asks reads for the file of each RRQ and
+// writes for the destination of each WRQ. A nil handler refuses the
+// corresponding requests, so NewServer(addrs, h, nil) is read-only.
+// ListenAndServe listens on each of addrs, host:port pairs such as
+// "[::]:69", or on DEFAULT_ADDRESS if there are none; Serve uses a socket
+// of the caller's.
+func NewServer(addrs []string, reads ReadHandler, writes WriteHandler) *Server {
+	return server.NewWithHandlers(addrs, reads, writes)
+}
+
+// NewClient returns a client for the server at serverAddr, a host:port pair.
+func NewClient(serverAddr string) *Client {
+	return client.New(serverAddr)
+}
+
+// NewServeMux returns an empty ServeMux.
+func NewServeMux() *ServeMux {
+	return server.NewServeMux()
+}
+
+// LoadACL reads an ACL from a file of rules; see ParseACL.
+func LoadACL(name string) (*ACL, error) {
+	return server.LoadACL(name)
+}
+
+// ParseACL reads an ACL with one rule per line, in the form
+//
+//	allow|deny  read|write|all  <CIDR, address or "any">  [path prefix]
+func ParseACL(r io.Reader) (*ACL, error) {
+	return server.ParseACL(r)
+}
+
+// ParsePortRange parses a port range written as "min-max", such as "50000-50099".
+func ParsePortRange(value string) (PortRange, error) {
+	return utils.ParsePortRange(value)
+}
+
+// NewError returns an ERROR packet with the standard message for code.
+func NewError(code uint16) Error {
+	return protocol.NewError(code)
+}
+
+// STAGING_DIR is the hidden directory under a LocalBackend's root that
+// holds uploads until they complete. Requests cannot reach it.
+const STAGING_DIR = server.STAGING_DIR
+
+// NewLocalBackend serves the directory dir. Close releases it.
+func NewLocalBackend(dir string) (*LocalBackend, error) {
+	return server.NewLocalBackend(dir)
+}
+
+// NewMemoryBackend returns an empty in-memory backend.
+func NewMemoryBackend() *MemoryBackend {
+	return server.NewMemoryBackend()
+}
+
+// NewFSBackend serves the files of fsys read-only.
+func NewFSBackend(fsys fs.FS) *FSBackend {
+	return server.NewFSBackend(fsys)
+}